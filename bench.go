@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// discardSink discards extracted files.
+type discardSink struct{}
+
+// WriteFile discards the contents of the given file.
+func (discardSink) WriteFile(archiveDir, filePath string, data []byte) error {
+	return nil
+}
+
+// Close is a no-op for discarding sinks.
+func (discardSink) Close() error {
+	return nil
+}
+
+// runBench extracts the given files of the MPQ archives without writing them,
+// and reports the decompression throughput; in total and per MPQ archive.
+// Files are located and read as during extraction, so that the throughput
+// reflects real workloads.
+func runBench(archives []*d2mpq.MPQ, filePaths []string, opts options) error {
+	opts.timings = &timings{}
+	start := time.Now()
+	summary, err := extractAllFiles(archives, filePaths, discardSink{}, opts)
+	elapsed := time.Since(start)
+	fmt.Println(summary)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	opts.timings.printThroughput(archives, summary.bytes, elapsed)
+	return nil
+}
+
+// printThroughput prints the number of files, bytes and read duration of the
+// files of each MPQ archive, along with the decompression throughput. The total
+// throughput is based on the given number of bytes and elapsed wall time.
+func (t *timings) printThroughput(archives []*d2mpq.MPQ, total int64, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	type archiveTiming struct {
+		files   int
+		bytes   int64
+		elapsed time.Duration
+	}
+	perArchive := make(map[*d2mpq.MPQ]*archiveTiming)
+	for _, entry := range t.entries {
+		at, ok := perArchive[entry.archive]
+		if !ok {
+			at = &archiveTiming{}
+			perArchive[entry.archive] = at
+		}
+		at.files++
+		at.bytes += int64(entry.size)
+		at.elapsed += entry.elapsed
+	}
+	// Report MPQ archives in order of read duration, slowest first.
+	var order []*d2mpq.MPQ
+	for _, archive := range archives {
+		if _, ok := perArchive[archive]; ok {
+			order = append(order, archive)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return perArchive[order[i]].elapsed > perArchive[order[j]].elapsed
+	})
+	for _, archive := range order {
+		at := perArchive[archive]
+		fmt.Printf("%q\t%d file(s)\t%d bytes\t%v\t%.2f MB/s\n", archive.FileName, at.files, at.bytes, at.elapsed, throughput(at.bytes, at.elapsed))
+	}
+	fmt.Printf("decompressed %d bytes in %v (%.2f MB/s)\n", total, elapsed, throughput(total, elapsed))
+}
+
+// throughput returns the throughput in MB/s of processing the given number of
+// bytes in the given duration.
+func throughput(n int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / 1e6 / elapsed.Seconds()
+}
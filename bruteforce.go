@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// bruteforceNames returns the candidate names of the given wordlist (one per
+// line, as in listfiles) whose name hashes match a file of any of the MPQ
+// archives not named by the given file paths. Each unnamed file is recovered at
+// most once, by the first matching candidate.
+func bruteforceNames(archives []*d2mpq.MPQ, filePaths []string, wordlistPath string) ([]string, error) {
+	entries, _, err := readListfile(wordlistPath, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	named := namedHashes(filePaths)
+	unnamed := make(map[nameHash]bool)
+	for _, archive := range archives {
+		for _, hash := range fileHashes(archive) {
+			if !named[hash] {
+				unnamed[hash] = true
+			}
+		}
+	}
+	total := len(unnamed)
+	var recovered []string
+	for _, entry := range entries {
+		if len(unnamed) == 0 {
+			break
+		}
+		filePath := denormalize(entry.filePath)
+		if len(filePath) == 0 {
+			continue
+		}
+		hash := fileNameHash(filePath)
+		if !unnamed[hash] {
+			continue
+		}
+		delete(unnamed, hash)
+		infof("recovered %q\n", normalize(filePath))
+		recovered = append(recovered, filePath)
+	}
+	fmt.Printf("recovered %d of %d unnamed file(s) using wordlist %q\n", len(recovered), total, wordlistPath)
+	return recovered, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/OpenDiablo2/MpqViewer/internal/lru"
+)
+
+// cacheKey identifies a file within an MPQ archive.
+type cacheKey struct {
+	// MPQ archive containing the file.
+	archive *d2mpq.MPQ
+	// Archive path of the file (see archivePath).
+	filePath string
+}
+
+// readCache is an LRU cache of decompressed file contents, bounded by the total
+// size in bytes of cached contents.
+type readCache struct {
+	files *lru.Cache[cacheKey]
+}
+
+// newReadCache returns a new LRU cache of decompressed file contents, holding at
+// most maxSize bytes.
+func newReadCache(maxSize int64) *readCache {
+	return &readCache{files: lru.New[cacheKey](maxSize)}
+}
+
+// get returns the cached contents of the given file, and a boolean indicating
+// whether the file was cached.
+func (c *readCache) get(archive *d2mpq.MPQ, filePath string) ([]byte, bool) {
+	return c.files.Get(cacheKey{archive: archive, filePath: filePath})
+}
+
+// put caches the contents of the given file, evicting the least recently used
+// files as needed to stay within the size limit. Files larger than the size
+// limit are not cached.
+func (c *readCache) put(archive *d2mpq.MPQ, filePath string, data []byte) {
+	c.files.Put(cacheKey{archive: archive, filePath: filePath}, data)
+}
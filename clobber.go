@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// existingPolicy specifies how files whose output path already exists are
+// extracted.
+type existingPolicy int
+
+// Policies of existing output files.
+const (
+	// Overwrite existing output files (default).
+	existingOverwrite existingPolicy = iota
+	// Skip files whose output path already exists.
+	existingNoClobber
+	// Skip files whose output file already has the uncompressed size recorded
+	// in the MPQ archive, resuming an interrupted extraction; overwrite other
+	// existing output files.
+	existingResume
+)
+
+// policyFlag is a boolean command line flag which selects a policy of existing
+// output files. Flags of the same policy variable override each other, so that
+// the flag given last takes precedence (e.g. "-no-clobber -resume-extract"
+// overwrites existing files of differing size).
+type policyFlag struct {
+	// Policy variable set by the flag; nil for the zero value.
+	policy *existingPolicy
+	// Policy selected by the flag.
+	value existingPolicy
+	// Policy selected by setting the flag to false.
+	negated existingPolicy
+}
+
+// IsBoolFlag reports that the flag may be given without value.
+func (f policyFlag) IsBoolFlag() bool {
+	return true
+}
+
+// String returns whether the policy of the flag is selected.
+func (f policyFlag) String() string {
+	if f.policy == nil {
+		return "false"
+	}
+	return strconv.FormatBool(*f.policy == f.value)
+}
+
+// Set selects the policy of the flag if s is true, and the negated policy of
+// the flag otherwise.
+func (f policyFlag) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return errors.Errorf("invalid boolean value %q", s)
+	}
+	if v {
+		*f.policy = f.value
+	} else {
+		*f.policy = f.negated
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func TestPolicyFlag(t *testing.T) {
+	golden := []struct {
+		name string
+		args []string
+		want existingPolicy
+	}{
+		{name: "default", args: nil, want: existingOverwrite},
+		{name: "overwrite", args: []string{"-overwrite"}, want: existingOverwrite},
+		{name: "no-clobber", args: []string{"-no-clobber"}, want: existingNoClobber},
+		{name: "no-clobber then overwrite", args: []string{"-no-clobber", "-overwrite"}, want: existingOverwrite},
+		{name: "overwrite then no-clobber", args: []string{"-overwrite", "-no-clobber"}, want: existingNoClobber},
+		{name: "overwrite false", args: []string{"-overwrite=false"}, want: existingNoClobber},
+		{name: "no-clobber false", args: []string{"-no-clobber", "-no-clobber=false"}, want: existingOverwrite},
+		{name: "resume-extract", args: []string{"-resume-extract"}, want: existingResume},
+		{name: "no-clobber then resume-extract", args: []string{"-no-clobber", "-resume-extract"}, want: existingResume},
+		{name: "resume-extract then no-clobber", args: []string{"-resume-extract", "-no-clobber"}, want: existingNoClobber},
+		{name: "resume-extract then overwrite", args: []string{"-resume-extract", "-overwrite"}, want: existingOverwrite},
+		{name: "resume-extract false", args: []string{"-resume-extract", "-resume-extract=false"}, want: existingOverwrite},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			var got existingPolicy
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(ioutil.Discard)
+			fs.Var(policyFlag{policy: &got, value: existingOverwrite, negated: existingNoClobber}, "overwrite", "")
+			fs.Var(policyFlag{policy: &got, value: existingNoClobber, negated: existingOverwrite}, "no-clobber", "")
+			fs.Var(policyFlag{policy: &got, value: existingResume, negated: existingOverwrite}, "resume-extract", "")
+			if err := fs.Parse(g.args); err != nil {
+				t.Fatal(err)
+			}
+			if got != g.want {
+				t.Errorf("policy mismatch; expected %v, got %v", g.want, got)
+			}
+		})
+	}
+}
+
+func TestPolicyFlagDefault(t *testing.T) {
+	var policy existingPolicy
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(policyFlag{policy: &policy, value: existingOverwrite, negated: existingNoClobber}, "overwrite", "")
+	fs.Var(policyFlag{policy: &policy, value: existingNoClobber, negated: existingOverwrite}, "no-clobber", "")
+	fs.Var(policyFlag{policy: &policy, value: existingResume, negated: existingOverwrite}, "resume-extract", "")
+	golden := []struct {
+		name string
+		want string
+	}{
+		{name: "overwrite", want: "true"},
+		{name: "no-clobber", want: "false"},
+		{name: "resume-extract", want: "false"},
+	}
+	for _, g := range golden {
+		if got := fs.Lookup(g.name).DefValue; got != g.want {
+			t.Errorf("-%s: default mismatch; expected %q, got %q", g.name, g.want, got)
+		}
+	}
+}
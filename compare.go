@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/pkg/errors"
+)
+
+// archiveDiff records the structural differences between two MPQ archives.
+type archiveDiff struct {
+	// Files only present in the first MPQ archive.
+	onlyA []string
+	// Files only present in the second MPQ archive.
+	onlyB []string
+	// Files present in both MPQ archives with differing contents.
+	differing []string
+	// Files present in both MPQ archives with identical contents.
+	identical []string
+}
+
+// compareArchives compares the file sets of the two MPQ archives, as given by
+// their embedded (listfile), and the contents of files present in both. No
+// files are written to disk.
+func compareArchives(a, b *d2mpq.MPQ) (*archiveDiff, error) {
+	filesA, err := archiveFileSet(a)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	filesB, err := archiveFileSet(b)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	diff := &archiveDiff{}
+	for key, filePath := range filesA {
+		if _, ok := filesB[key]; !ok {
+			diff.onlyA = append(diff.onlyA, filePath)
+			continue
+		}
+		dataA, err := archiveReadFile(a, filePath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		dataB, err := archiveReadFile(b, filePath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if sha256.Sum256(dataA) == sha256.Sum256(dataB) {
+			diff.identical = append(diff.identical, filePath)
+		} else {
+			diff.differing = append(diff.differing, filePath)
+		}
+	}
+	for key, filePath := range filesB {
+		if _, ok := filesA[key]; !ok {
+			diff.onlyB = append(diff.onlyB, filePath)
+		}
+	}
+	sort.Strings(diff.onlyA)
+	sort.Strings(diff.onlyB)
+	sort.Strings(diff.differing)
+	sort.Strings(diff.identical)
+	return diff, nil
+}
+
+// archiveFileSet returns the set of files listed in the embedded (listfile) of
+// the MPQ archive and present in the archive, mapping from case-insensitive
+// archive path to file path.
+func archiveFileSet(archive *d2mpq.MPQ) (map[string]string, error) {
+	files, err := archive.GetFileList()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	set := make(map[string]string)
+	for _, filePath := range files {
+		filePath = denormalize(filePath)
+		if len(filePath) == 0 || !archive.FileExists(filePath) {
+			continue
+		}
+		set[archivePath(filePath)] = filePath
+	}
+	return set, nil
+}
+
+// printArchiveDiff prints the structural differences between the two MPQ
+// archives.
+func printArchiveDiff(a, b *d2mpq.MPQ, diff *archiveDiff, opts options) {
+	nameA := pathutil.FileName(a.FileName)
+	nameB := pathutil.FileName(b.FileName)
+	sections := []struct {
+		title string
+		files []string
+	}{
+		{title: fmt.Sprintf("only in %q", nameA), files: diff.onlyA},
+		{title: fmt.Sprintf("only in %q", nameB), files: diff.onlyB},
+		{title: "differing", files: diff.differing},
+		{title: "identical", files: diff.identical},
+	}
+	for _, section := range sections {
+		fmt.Printf("%d file(s) %s\n", len(section.files), section.title)
+		for _, filePath := range section.files {
+			fmt.Printf("\t%s\n", opts.pathStyle.format(normalize(filePath)))
+		}
+	}
+}
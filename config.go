@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// configFlag returns the path of the config file specified by the -config flag
+// of the given command line arguments; or the empty string if not present. The
+// arguments are scanned before flag parsing, so that the config file may be
+// applied as defaults of the remaining flags.
+func configFlag(args []string) string {
+	for i, arg := range args {
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		switch {
+		case name == "config" && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(name, "config="):
+			return name[len("config="):]
+		}
+	}
+	return ""
+}
+
+// loadConfig applies the flag values of the given JSON config file. The config
+// file is a JSON object whose keys are flag names (without leading dash) and
+// whose values are strings, numbers or booleans; e.g.
+//
+//	{"a": true, "embedded": true, "mpq_dir": "/path/to/diablo_ii", "jobs": 4}
+//
+// loadConfig is called before flag parsing, so that flags specified on the
+// command line take precedence over those of the config file.
+func loadConfig(configPath string) error {
+	buf, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(buf, &config); err != nil {
+		return errors.Wrapf(err, "unable to parse config file %q", configPath)
+	}
+	var names []string
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" {
+			return errors.Errorf("invalid flag %q of config file %q; config files cannot be nested", name, configPath)
+		}
+		if flag.Lookup(name) == nil {
+			return errors.Errorf("unknown flag %q of config file %q", name, configPath)
+		}
+		var value string
+		switch v := config[name].(type) {
+		case string:
+			value = v
+		case bool, float64:
+			value = fmt.Sprint(v)
+		default:
+			return errors.Errorf("invalid value %v of flag %q of config file %q; expected string, number or boolean", v, name, configPath)
+		}
+		if err := flag.Set(name, value); err != nil {
+			return errors.Wrapf(err, "invalid value %q of flag %q of config file %q", value, name, configPath)
+		}
+	}
+	return nil
+}
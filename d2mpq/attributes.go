@@ -0,0 +1,179 @@
+package d2mpq
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// attributesFileName is the name of the internal file of MPQ archives storing
+// per-block metadata.
+const attributesFileName = "(attributes)"
+
+// Flags of the (attributes) file, specifying which per-block metadata arrays
+// are present.
+const (
+	attributesCRC32    = 0x00000001
+	attributesFileTime = 0x00000002
+	attributesMD5      = 0x00000004
+	attributesPatchBit = 0x00000008
+)
+
+// ErrNoAttributes is returned when the MPQ archive has no (attributes) file,
+// or it lacks the requested metadata.
+var ErrNoAttributes = errors.New("mpq archive has no (attributes) file")
+
+// Attributes holds the per-block metadata of the (attributes) file. Each slice
+// is indexed by block index, and nil if not present in the (attributes) file.
+type Attributes struct {
+	// Version of the (attributes) file; 100.
+	Version uint32
+	// Flags specifying which per-block metadata is present.
+	Flags uint32
+	// CRC32 (IEEE) of the uncompressed contents of each block.
+	CRC32 []uint32
+	// Modification time of each block, as Windows FILETIME; 0 if unknown.
+	FileTimes []uint64
+	// MD5 of the uncompressed contents of each block.
+	MD5 [][16]byte
+}
+
+// FileTime returns the modification time of the given block; or the zero time
+// if unknown.
+func (a *Attributes) FileTime(blockIndex uint32) time.Time {
+	if blockIndex >= uint32(len(a.FileTimes)) || a.FileTimes[blockIndex] == 0 {
+		return time.Time{}
+	}
+	return fileTimeToTime(a.FileTimes[blockIndex])
+}
+
+// attributesCache memoizes the parsed (attributes) file of an MPQ archive.
+type attributesCache struct {
+	once  sync.Once
+	attrs *Attributes
+	err   error
+}
+
+// Attributes returns the parsed (attributes) file of the MPQ archive, keyed by
+// block index. ErrNoAttributes is returned if the MPQ archive has no
+// (attributes) file. The result is shared by all callers and must not be
+// modified.
+func (v MPQ) Attributes() (*Attributes, error) {
+	c := v.attrs
+	if c == nil {
+		return v.parseAttributes()
+	}
+	c.once.Do(func() {
+		c.attrs, c.err = v.parseAttributes()
+	})
+	return c.attrs, c.err
+}
+
+// parseAttributes parses the (attributes) file of the MPQ archive.
+//
+// The (attributes) file starts with a 32-bit version and flags, followed by one
+// array per flag set (CRC32, FILETIME, MD5 and patch bits, in that order) of
+// one element per block table entry. Arrays shorter than the block table (e.g.
+// lacking the entry of the (attributes) file itself) are accepted.
+func (v MPQ) parseAttributes() (*Attributes, error) {
+	if !v.FileExists(attributesFileName) {
+		return nil, ErrNoAttributes
+	}
+	data, err := v.ReadFile(attributesFileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, errors.New("invalid (attributes) file; header too short")
+	}
+	attrs := &Attributes{
+		Version: binary.LittleEndian.Uint32(data[0:4]),
+		Flags:   binary.LittleEndian.Uint32(data[4:8]),
+	}
+	data = data[8:]
+	// Number of entries of each array; clamped to the available data.
+	n := len(v.BlockTableEntries)
+	entrySize := 0
+	if attrs.Flags&attributesCRC32 != 0 {
+		entrySize += 4
+	}
+	if attrs.Flags&attributesFileTime != 0 {
+		entrySize += 8
+	}
+	if attrs.Flags&attributesMD5 != 0 {
+		entrySize += 16
+	}
+	if entrySize > 0 && n*entrySize > len(data) {
+		n = len(data) / entrySize
+	}
+	if attrs.Flags&attributesCRC32 != 0 {
+		attrs.CRC32 = make([]uint32, n)
+		for i := range attrs.CRC32 {
+			attrs.CRC32[i] = binary.LittleEndian.Uint32(data[4*i:])
+		}
+		data = data[4*n:]
+	}
+	if attrs.Flags&attributesFileTime != 0 {
+		attrs.FileTimes = make([]uint64, n)
+		for i := range attrs.FileTimes {
+			attrs.FileTimes[i] = binary.LittleEndian.Uint64(data[8*i:])
+		}
+		data = data[8*n:]
+	}
+	if attrs.Flags&attributesMD5 != 0 {
+		attrs.MD5 = make([][16]byte, n)
+		for i := range attrs.MD5 {
+			copy(attrs.MD5[i][:], data[16*i:])
+		}
+	}
+	return attrs, nil
+}
+
+// fileTimeEpoch is the number of 100-nanosecond intervals between the Windows
+// FILETIME epoch (1601-01-01) and the Unix epoch (1970-01-01).
+const fileTimeEpoch = 116444736000000000
+
+// fileTimeToTime converts the given Windows FILETIME to time.
+func fileTimeToTime(ft uint64) time.Time {
+	return time.Unix(0, (int64(ft)-fileTimeEpoch)*100).UTC()
+}
+
+// FileTime returns the modification time of the given file, as recorded in the
+// (attributes) file of the MPQ archive. The zero time is returned if the file
+// is not found or has no recorded modification time. ErrNoAttributes is
+// returned if the MPQ archive has no (attributes) file or it lacks
+// modification times.
+func (v MPQ) FileTime(fileName string) (time.Time, error) {
+	attrs, err := v.Attributes()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if attrs.FileTimes == nil {
+		return time.Time{}, ErrNoAttributes
+	}
+	fileEntry, err := v.getFileHashEntry(fileName)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return attrs.FileTime(fileEntry.BlockIndex), nil
+}
+
+// FileCRC32 returns the CRC32 (IEEE) of the uncompressed contents of the given
+// file, as recorded in the (attributes) file of the MPQ archive, and reports
+// whether a CRC32 is recorded for the file. ErrNoAttributes is returned if the
+// MPQ archive has no (attributes) file or it lacks CRC32 values.
+func (v MPQ) FileCRC32(fileName string) (uint32, bool, error) {
+	attrs, err := v.Attributes()
+	if err != nil {
+		return 0, false, err
+	}
+	if attrs.CRC32 == nil {
+		return 0, false, ErrNoAttributes
+	}
+	fileEntry, err := v.getFileHashEntry(fileName)
+	if err != nil || fileEntry.BlockIndex >= uint32(len(attrs.CRC32)) {
+		return 0, false, nil
+	}
+	return attrs.CRC32[fileEntry.BlockIndex], true, nil
+}
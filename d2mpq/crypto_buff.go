@@ -0,0 +1,33 @@
+package d2mpq
+
+import "errors"
+
+// ErrCryptoUninitialized is returned when loading MPQ archives or reading files
+// before the crypto buffer has been initialized using InitializeCryptoBuffer;
+// hash tables, block tables and encrypted files decrypt to garbage without it.
+var ErrCryptoUninitialized = errors.New("crypto buffer not initialized; call d2mpq.InitializeCryptoBuffer before loading MPQ archives")
+
+// CryptoBuffer contains the crypto bytes for filename hashing
+var CryptoBuffer [0x500]uint32
+
+// InitializeCryptoBuffer initializes the crypto buffer
+func InitializeCryptoBuffer() {
+	seed := uint32(0x00100001)
+	for index1 := 0; index1 < 0x100; index1++ {
+		index2 := index1
+		for i := 0; i < 5; i++ {
+			seed = (seed*125 + 3) % 0x2AAAAB
+			temp1 := (seed & 0xFFFF) << 0x10
+			seed = (seed*125 + 3) % 0x2AAAAB
+			temp2 := (seed & 0xFFFF)
+			CryptoBuffer[index2] = temp1 | temp2
+			index2 += 0x100
+		}
+	}
+}
+
+// cryptoBufferInitialized reports whether the crypto buffer has been
+// initialized using InitializeCryptoBuffer.
+func cryptoBufferInitialized() bool {
+	return CryptoBuffer[0] != 0
+}
@@ -0,0 +1,673 @@
+// Package d2mpq implements access to files within MPQ archives.
+//
+// The package is derived from the d2mpq package of OpenDiablo2.
+package d2mpq
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/OpenDiablo2/MpqViewer/internal/lru"
+	"github.com/OpenDiablo2/OpenDiablo2/d2common/d2resource"
+	"golang.org/x/sync/singleflight"
+)
+
+// MPQ represents an MPQ archive
+type MPQ struct {
+	FileName          string
+	File              *os.File
+	HashTableEntries  []HashTableEntry
+	BlockTableEntries []BlockTableEntry
+	Data              Data
+	DataV2            DataV2
+	fileCache         map[string][]byte
+	fileList          *fileListCache
+	attrs             *attributesCache
+	sectors           *lru.Cache[sectorKey]
+	// Repairs lists the un-protection heuristics applied by LoadProtected.
+	Repairs []string
+	// UserData is the user-data header preceding the MPQ header, if any.
+	UserData UserData
+	// HeaderOffset is the offset of the MPQ header within the file; non-zero
+	// for archives preceded by other data, such as a user-data block. Table
+	// and file offsets of the archive are relative to the MPQ header.
+	HeaderOffset int64
+}
+
+// fileListCache memoizes the parsed (listfile) of an MPQ archive. Concurrent
+// callers of GetFileList share a single parse of the listfile.
+type fileListCache struct {
+	group singleflight.Group
+	mu    sync.Mutex
+	files []string
+}
+
+// Data Represents a MPQ file
+type Data struct {
+	Magic             [4]byte
+	HeaderSize        uint32
+	ArchiveSize       uint32
+	FormatVersion     uint16
+	BlockSize         uint16
+	HashTableOffset   uint32
+	BlockTableOffset  uint32
+	HashTableEntries  uint32
+	BlockTableEntries uint32
+}
+
+// DataV2 represents the extended header of MPQ format version 2 (the Burning
+// Crusade), which adds the high bits of 64-bit table and file offsets.
+type DataV2 struct {
+	// Offset of the table of high 16 bits of file offsets.
+	HiBlockTableOffset uint64
+	// High 16 bits of the hash table offset.
+	HashTableOffsetHi uint16
+	// High 16 bits of the block table offset.
+	BlockTableOffsetHi uint16
+}
+
+// Format versions of MPQ archives.
+const (
+	// FormatVersion1 - original format, up to 4 GB.
+	FormatVersion1 = 0
+	// FormatVersion2 - extended format with 64-bit offsets.
+	FormatVersion2 = 1
+)
+
+// hashTablePos returns the 64-bit offset of the hash table.
+func (v MPQ) hashTablePos() int64 {
+	return v.HeaderOffset + (int64(v.Data.HashTableOffset) | int64(v.DataV2.HashTableOffsetHi)<<32)
+}
+
+// blockTablePos returns the 64-bit offset of the block table.
+func (v MPQ) blockTablePos() int64 {
+	return v.HeaderOffset + (int64(v.Data.BlockTableOffset) | int64(v.DataV2.BlockTableOffsetHi)<<32)
+}
+
+// HashTablePosition returns the offset of the hash table within the file.
+func (v MPQ) HashTablePosition() int64 {
+	return v.hashTablePos()
+}
+
+// BlockTablePosition returns the offset of the block table within the file.
+func (v MPQ) BlockTablePosition() int64 {
+	return v.blockTablePos()
+}
+
+// SectorSize returns the size in bytes of the sectors of files in the MPQ
+// archive, as specified by the sector size shift (BlockSize) of the header.
+func (v MPQ) SectorSize() uint32 {
+	return 0x200 << v.Data.BlockSize
+}
+
+// HashTableEntry represents a hashed file entry in the MPQ file
+type HashTableEntry struct { // 16 bytes
+	NamePartA  uint32
+	NamePartB  uint32
+	Locale     uint16
+	Platform   uint16
+	BlockIndex uint32
+}
+
+// Special values of the block index of hash table entries.
+const (
+	// BlockIndexFree marks a hash table entry which has never been used;
+	// lookup of a file name stops at a free entry.
+	BlockIndexFree = 0xFFFFFFFF
+	// BlockIndexDeleted marks a hash table entry of a deleted file; lookup of a
+	// file name continues past a deleted entry.
+	BlockIndexDeleted = 0xFFFFFFFE
+)
+
+type PatchInfo struct {
+	Length   uint32   // Length of patch info header, in bytes
+	Flags    uint32   // Flags. 0x80000000 = MD5 (?)
+	DataSize uint32   // Uncompressed size of the patch file
+	Md5      [16]byte // MD5 of the entire patch file after decompression
+}
+
+// FileFlag represents flags for a file record in the MPQ archive
+type FileFlag uint32
+
+const (
+	// FileImplode - File is compressed using PKWARE Data compression library
+	FileImplode FileFlag = 0x00000100
+	// FileCompress - File is compressed using combination of compression methods
+	FileCompress FileFlag = 0x00000200
+	// FileEncrypted - The file is encrypted
+	FileEncrypted FileFlag = 0x00010000
+	// FileFixKey - The decryption key for the file is altered according to the position of the file in the archive
+	FileFixKey FileFlag = 0x00020000
+	// FilePatchFile - The file contains incremental patch for an existing file in base MPQ
+	FilePatchFile FileFlag = 0x00100000
+	// FileSingleUnit - Instead of being divided to 0x1000-bytes blocks, the file is stored as single unit
+	FileSingleUnit FileFlag = 0x01000000
+	// FileDeleteMarker - File is a deletion marker, indicating that the file no longer exists. This is used to allow patch
+	// archives to delete files present in lower-priority archives in the search chain. The file usually
+	// has length of 0 or 1 byte and its name is a hash
+	FileDeleteMarker FileFlag = 0x02000000
+	// FileSectorCrc - File has checksums for each sector. Ignored if file is not compressed or imploded.
+	FileSectorCrc FileFlag = 0x04000000
+	// FileExists - Set if file exists, reset when the file was deleted
+	FileExists FileFlag = 0x80000000
+)
+
+// BlockTableEntry represents an entry in the block table
+type BlockTableEntry struct { // 16 bytes
+	FilePosition         uint32
+	CompressedFileSize   uint32
+	UncompressedFileSize uint32
+	Flags                FileFlag
+	// High 16 bits of the file offset; from the hi-block table of format
+	// version 2 and later.
+	FilePositionHi uint16
+	// Offset of the MPQ header within the file.
+	headerOffset int64
+	// Local Stuff...
+	FileName       string
+	EncryptionSeed uint32
+}
+
+// Position returns the 64-bit offset of the file within the MPQ file.
+func (v BlockTableEntry) Position() int64 {
+	return v.headerOffset + (int64(v.FilePosition) | int64(v.FilePositionHi)<<32)
+}
+
+// HasFlag returns true if the specified flag is present
+func (v BlockTableEntry) HasFlag(flag FileFlag) bool {
+	return (v.Flags & flag) != 0
+}
+
+var mpqMutex = sync.Mutex{}
+var mpqCache = make(map[string]*MPQ)
+
+// Load loads an MPQ file and returns a MPQ structure. ErrCryptoUninitialized is
+// returned if the crypto buffer has not yet been initialized.
+func Load(fileName string) (*MPQ, error) {
+	if !cryptoBufferInitialized() {
+		return nil, ErrCryptoUninitialized
+	}
+	mpqMutex.Lock()
+	defer mpqMutex.Unlock()
+	cached := mpqCache[fileName]
+	if cached != nil {
+		return cached, nil
+	}
+	result := &MPQ{
+		FileName:  fileName,
+		fileCache: make(map[string][]byte),
+		fileList:  &fileListCache{},
+		attrs:     &attributesCache{},
+	}
+	file, err := openShared(fileName)
+	if err != nil {
+		return nil, err
+	}
+	result.File = file
+	err = result.readHeader()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	mpqCache[fileName] = result
+	return result, nil
+}
+
+func (v *MPQ) readHeader() error {
+	if err := v.seekHeader(); err != nil {
+		return err
+	}
+	err := binary.Read(v.File, binary.LittleEndian, &v.Data)
+	if err != nil {
+		return err
+	}
+	if string(v.Data.Magic[:]) != headerMagic {
+		return errors.New("invalid mpq header")
+	}
+	if v.Data.FormatVersion >= FormatVersion2 {
+		err := binary.Read(v.File, binary.LittleEndian, &v.DataV2)
+		if err != nil {
+			return err
+		}
+	}
+	if err := v.loadHashTable(); err != nil {
+		return err
+	}
+	if err := v.loadBlockTable(); err != nil {
+		return err
+	}
+	if v.DataV2.HiBlockTableOffset != 0 {
+		if err := v.loadHiBlockTable(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadHashTable loads the hash table of the MPQ archive.
+func (v *MPQ) loadHashTable() error {
+	hashData, err := v.readTable("hash table", v.hashTablePos(), v.Data.HashTableEntries, 4)
+	if err != nil {
+		return err
+	}
+	decrypt(hashData, hashString("(hash table)", 3))
+	for i := uint32(0); i < v.Data.HashTableEntries; i++ {
+		v.HashTableEntries = append(v.HashTableEntries, HashTableEntry{
+			NamePartA:  hashData[i*4],
+			NamePartB:  hashData[(i*4)+1],
+			Locale:     uint16(hashData[(i*4)+2] & 0xFFFF),
+			Platform:   uint16(hashData[(i*4)+2] >> 16),
+			BlockIndex: hashData[(i*4)+3],
+		})
+	}
+	return nil
+}
+
+// loadBlockTable loads the block table of the MPQ archive.
+func (v *MPQ) loadBlockTable() error {
+	blockData, err := v.readTable("block table", v.blockTablePos(), v.Data.BlockTableEntries, 4)
+	if err != nil {
+		return err
+	}
+	decrypt(blockData, hashString("(block table)", 3))
+	for i := uint32(0); i < v.Data.BlockTableEntries; i++ {
+		v.BlockTableEntries = append(v.BlockTableEntries, BlockTableEntry{
+			FilePosition:         blockData[(i * 4)],
+			CompressedFileSize:   blockData[(i*4)+1],
+			UncompressedFileSize: blockData[(i*4)+2],
+			Flags:                FileFlag(blockData[(i*4)+3]),
+			headerOffset:         v.HeaderOffset,
+		})
+	}
+	return nil
+}
+
+// loadHiBlockTable loads the high 16 bits of file offsets from the hi-block
+// table of format version 2 and later.
+func (v *MPQ) loadHiBlockTable() error {
+	pos := v.HeaderOffset + int64(v.DataV2.HiBlockTableOffset)
+	if err := v.checkTable("hi-block table", pos, int64(v.Data.BlockTableEntries)*2); err != nil {
+		return err
+	}
+	if _, err := v.File.Seek(pos, io.SeekStart); err != nil {
+		return fmt.Errorf("unable to read hi-block table; %w", err)
+	}
+	hiData := make([]uint16, v.Data.BlockTableEntries)
+	if err := binary.Read(v.File, binary.LittleEndian, &hiData); err != nil {
+		return fmt.Errorf("unable to read hi-block table; %w", err)
+	}
+	for i := range v.BlockTableEntries {
+		v.BlockTableEntries[i].FilePositionHi = hiData[i]
+	}
+	return nil
+}
+
+// readTable reads the given number of entries, each of the given number of
+// 32-bit words, of the named table at the given offset of the MPQ file.
+func (v *MPQ) readTable(name string, pos int64, entries, words uint32) ([]uint32, error) {
+	n := int64(entries) * int64(words)
+	if err := v.checkTable(name, pos, 4*n); err != nil {
+		return nil, err
+	}
+	if _, err := v.File.Seek(pos, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("unable to read %s; %w", name, err)
+	}
+	data := make([]uint32, n)
+	if err := binary.Read(v.File, binary.LittleEndian, &data); err != nil {
+		return nil, fmt.Errorf("unable to read %s; %w", name, err)
+	}
+	return data, nil
+}
+
+// checkTable checks that the named table of the given size in bytes at the
+// given offset lies within the MPQ file, so that tables of malformed archives
+// are not allocated.
+func (v *MPQ) checkTable(name string, pos, size int64) error {
+	fi, err := v.File.Stat()
+	if err != nil {
+		return err
+	}
+	if pos < 0 || pos+size > fi.Size() {
+		return fmt.Errorf("%s of %d bytes at offset 0x%X extends past end of file of %d bytes", name, size, pos, fi.Size())
+	}
+	return nil
+}
+
+func decrypt(data []uint32, seed uint32) {
+	seed2 := uint32(0xeeeeeeee)
+
+	for i := 0; i < len(data); i++ {
+		seed2 += CryptoBuffer[0x400+(seed&0xff)]
+		result := data[i]
+		result ^= seed + seed2
+
+		seed = ((^seed << 21) + 0x11111111) | (seed >> 11)
+		seed2 = result + seed2 + (seed2 << 5) + 3
+		data[i] = result
+	}
+}
+
+func decryptBytes(data []byte, seed uint32) {
+	seed2 := uint32(0xEEEEEEEE)
+	for i := 0; i < len(data)-3; i += 4 {
+		seed2 += CryptoBuffer[0x400+(seed&0xFF)]
+		result := binary.LittleEndian.Uint32(data[i : i+4])
+		result ^= seed + seed2
+		seed = ((^seed << 21) + 0x11111111) | (seed >> 11)
+		seed2 = result + seed2 + (seed2 << 5) + 3
+
+		data[i+0] = uint8(result & 0xff)
+		data[i+1] = uint8((result >> 8) & 0xff)
+		data[i+2] = uint8((result >> 16) & 0xff)
+		data[i+3] = uint8((result >> 24) & 0xff)
+	}
+}
+
+func hashString(key string, hashType uint32) uint32 {
+
+	seed1 := uint32(0x7FED7FED)
+	seed2 := uint32(0xEEEEEEEE)
+
+	/* prepare seeds. */
+	for _, char := range strings.ToUpper(key) {
+		seed1 = CryptoBuffer[(hashType*0x100)+uint32(char)] ^ (seed1 + seed2)
+		seed2 = uint32(char) + seed1 + seed2 + (seed2 << 5) + 3
+	}
+	return seed1
+}
+
+// HashFileName returns the MPQ hashes of the given file path; the hash table
+// offset (index modulo the hash table size at which lookup starts), and the
+// name A and name B hashes stored in hash table entries. Forward slashes of the
+// file path are treated as backslashes, and case is ignored. The crypto buffer
+// must have been initialized using InitializeCryptoBuffer.
+func HashFileName(path string) (index, hashA, hashB uint32) {
+	path = strings.ReplaceAll(path, "/", "\\")
+	return hashString(path, 0), hashString(path, 1), hashString(path, 2)
+}
+
+// FileKey returns the encryption key of the given file path, derived from its
+// base name; before the adjustment by file position and size of files with the
+// FileFixKey flag. Forward slashes of the file path are treated as backslashes.
+// The crypto buffer must have been initialized using InitializeCryptoBuffer.
+func FileKey(path string) uint32 {
+	path = strings.ReplaceAll(path, "/", "\\")
+	return hashString(path[strings.LastIndex(path, "\\")+1:], 3)
+}
+
+// getFileHashEntry returns the hash table entry of the given file. As done by
+// Storm, lookup starts at the hash table offset of the file name and probes
+// subsequent entries (wrapping around) until an entry of the file name is found
+// or a free entry is reached; deleted entries are skipped.
+func (v MPQ) getFileHashEntry(fileName string) (HashTableEntry, error) {
+	n := uint32(len(v.HashTableEntries))
+	if n > 0 {
+		start, hashA, hashB := HashFileName(fileName)
+		for i := uint32(0); i < n; i++ {
+			entry := v.HashTableEntries[(start+i)%n]
+			if entry.BlockIndex == BlockIndexFree {
+				break
+			}
+			if entry.BlockIndex != BlockIndexDeleted && entry.NamePartA == hashA && entry.NamePartB == hashB {
+				return entry, nil
+			}
+		}
+	}
+	return HashTableEntry{}, errors.New("file not found")
+}
+
+// FileHashEntry returns the hash table entry to which the given file resolves,
+// and a boolean indicating whether the file was found.
+func (v MPQ) FileHashEntry(fileName string) (HashTableEntry, bool) {
+	entry, err := v.getFileHashEntry(fileName)
+	return entry, err == nil
+}
+
+// getFileBlockData returns the block table entry of the given file, and its
+// index in the block table.
+func (v MPQ) getFileBlockData(fileName string) (BlockTableEntry, uint32, error) {
+	fileEntry, err := v.getFileHashEntry(fileName)
+	if err != nil {
+		return BlockTableEntry{}, 0, err
+	}
+	if fileEntry.BlockIndex >= uint32(len(v.BlockTableEntries)) {
+		return BlockTableEntry{}, 0, fmt.Errorf("block index %d of %q exceeds block table of %d entries", fileEntry.BlockIndex, fileName, len(v.BlockTableEntries))
+	}
+	return v.BlockTableEntries[fileEntry.BlockIndex], fileEntry.BlockIndex, nil
+}
+
+// FileSize returns the uncompressed size of the given file, as recorded in the
+// block table entry of the file, and a boolean indicating whether the file was
+// found. The file contents are not read.
+func (v MPQ) FileSize(fileName string) (uint32, bool) {
+	block, ok := v.FileBlock(fileName)
+	if !ok {
+		return 0, false
+	}
+	return block.UncompressedFileSize, true
+}
+
+// FileBlock returns the block table entry of the given file, recording its
+// offset, compressed and uncompressed size and flags, and a boolean indicating
+// whether the file was found. The file contents are not read.
+func (v MPQ) FileBlock(fileName string) (BlockTableEntry, bool) {
+	fileEntry, err := v.getFileHashEntry(fileName)
+	if err != nil || fileEntry.BlockIndex >= uint32(len(v.BlockTableEntries)) {
+		return BlockTableEntry{}, false
+	}
+	return v.BlockTableEntries[fileEntry.BlockIndex], true
+}
+
+// FileCount returns the number of files of the MPQ archive; i.e. the number of
+// occupied hash table entries referencing an existing block. Files with
+// multiple locales are counted once per locale.
+func (v MPQ) FileCount() int {
+	n := 0
+	for _, entry := range v.HashTableEntries {
+		if entry.BlockIndex >= uint32(len(v.BlockTableEntries)) {
+			continue
+		}
+		if v.BlockTableEntries[entry.BlockIndex].HasFlag(FileExists) {
+			n++
+		}
+	}
+	return n
+}
+
+// Close closes the MPQ file
+func (v *MPQ) Close() {
+	err := v.File.Close()
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func (v MPQ) FileExists(fileName string) bool {
+	_, err := v.getFileHashEntry(fileName)
+	return err == nil
+}
+
+// ReadFile reads a file from the MPQ and returns a memory stream. An error
+// wrapping io.ErrUnexpectedEOF is returned if the file contents are shorter
+// than the uncompressed size recorded in the block table.
+// ErrCryptoUninitialized is returned if the crypto buffer has not yet been
+// initialized.
+func (v MPQ) ReadFile(fileName string) ([]byte, error) {
+	if !cryptoBufferInitialized() {
+		return nil, ErrCryptoUninitialized
+	}
+	fileName = cleanFileName(fileName)
+	cached := v.fileCache[fileName]
+	if cached != nil {
+		return cached, nil
+	}
+	mpqStream, err := v.openStream(fileName)
+	if err != nil {
+		return []byte{}, err
+	}
+	size := mpqStream.BlockTableEntry.UncompressedFileSize
+	buffer := make([]byte, size)
+	n, err := mpqStream.Read(buffer, 0, size)
+	if err != nil {
+		return []byte{}, err
+	}
+	if n != size {
+		return []byte{}, fmt.Errorf("unable to read %q; read %d of %d bytes; %w", fileName, n, size, io.ErrUnexpectedEOF)
+	}
+	if v.fileCache != nil {
+		v.fileCache[fileName] = buffer
+	}
+	return buffer, nil
+}
+
+// OpenFile opens the given file of the MPQ archive for streaming. The returned
+// reader decompresses the file sector by sector on demand, rather than holding
+// the entire file contents in memory; though files stored as a single unit are
+// decompressed at once. Reads are not served from, nor added to, the file
+// cache. The MPQ archive must not be read concurrently while streaming.
+// ErrCryptoUninitialized is returned if the crypto buffer has not yet been
+// initialized.
+func (v MPQ) OpenFile(fileName string) (io.ReadCloser, error) {
+	if !cryptoBufferInitialized() {
+		return nil, ErrCryptoUninitialized
+	}
+	mpqStream, err := v.openStream(cleanFileName(fileName))
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader{stream: mpqStream}, nil
+}
+
+// cleanFileName returns the file name as stored in MPQ archives; i.e.
+// lowercase and backslash-separated, with {LANG} replaced by the language
+// code.
+func cleanFileName(fileName string) string {
+	fileName = strings.ReplaceAll(fileName, "{LANG}", d2resource.LanguageCode)
+	fileName = strings.ToLower(fileName)
+	return strings.ReplaceAll(fileName, `/`, "\\")
+}
+
+// openStream returns a stream of the given file, as stored in MPQ archives.
+func (v MPQ) openStream(fileName string) (*Stream, error) {
+	fileBlockData, blockIndex, err := v.getFileBlockData(fileName)
+	if err != nil {
+		return nil, err
+	}
+	fileBlockData.FileName = strings.ToLower(fileName)
+	fileBlockData.calculateEncryptionSeed()
+	stream, err := CreateStream(v, fileBlockData, fileName)
+	if err != nil {
+		return nil, err
+	}
+	stream.blockIndex = int64(blockIndex)
+	return stream, nil
+}
+
+// fileReader is a reader of the decompressed contents of a file of an MPQ
+// archive.
+type fileReader struct {
+	stream *Stream
+}
+
+// Read reads up to len(p) bytes of the decompressed file contents into p.
+func (r *fileReader) Read(p []byte) (int, error) {
+	remaining := r.stream.BlockTableEntry.UncompressedFileSize - r.stream.CurrentPosition
+	if remaining == 0 {
+		return 0, io.EOF
+	}
+	if uint32(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	read, err := r.stream.Read(p, 0, uint32(len(p)))
+	if err != nil {
+		return int(read), err
+	}
+	if read == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(read), nil
+}
+
+// Close closes the reader. The MPQ archive remains open.
+func (r *fileReader) Close() error {
+	return nil
+}
+
+// DisableFileCache disables caching of the decompressed contents of files read
+// from the MPQ, and releases any previously cached contents
+func (v *MPQ) DisableFileCache() {
+	v.fileCache = nil
+}
+
+// ReadTextFile reads a file and returns it as a string
+func (v MPQ) ReadTextFile(fileName string) (string, error) {
+	data, err := v.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (v *BlockTableEntry) calculateEncryptionSeed() {
+	fileName := path.Base(v.FileName)
+	v.EncryptionSeed = hashString(fileName, 3)
+	if !v.HasFlag(FileFixKey) {
+		return
+	}
+	v.EncryptionSeed = (v.EncryptionSeed + v.FilePosition) ^ v.UncompressedFileSize
+}
+
+// GetFileList returns the list of files in this MPQ. The listfile is parsed
+// once; concurrent calls wait for the result of the pending parse.
+func (v *MPQ) GetFileList() ([]string, error) {
+	c := v.fileList
+	c.mu.Lock()
+	files := c.files
+	c.mu.Unlock()
+	if files == nil {
+		result, err, _ := c.group.Do("(listfile)", func() (interface{}, error) {
+			files, err := v.parseFileList()
+			if err != nil {
+				return nil, err
+			}
+			c.mu.Lock()
+			c.files = files
+			c.mu.Unlock()
+			return files, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		files = result.([]string)
+	}
+	return append([]string(nil), files...), nil
+}
+
+// parseFileList parses the (listfile) of this MPQ. The (listfile) is read using
+// the regular file read path, so an encrypted (listfile) is decrypted using the
+// key derived from its file name (adjusted by FileFixKey when set).
+func (v *MPQ) parseFileList() ([]string, error) {
+	data, err := v.ReadFile("(listfile)")
+	if err != nil {
+		return nil, err
+	}
+	raw := strings.TrimRight(string(data), "\x00")
+	s := bufio.NewScanner(strings.NewReader(raw))
+	var filePaths []string
+	for s.Scan() {
+		filePath := s.Text()
+		filePaths = append(filePaths, filePath)
+	}
+	if filePaths == nil {
+		filePaths = []string{}
+	}
+	return filePaths, nil
+}
@@ -0,0 +1,410 @@
+package d2mpq
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/OpenDiablo2/OpenDiablo2/d2helper"
+
+	"github.com/JoshVarga/blast"
+	"github.com/OpenDiablo2/OpenDiablo2/d2data/d2compression"
+)
+
+// Stream represents a stream of data in an MPQ archive
+type Stream struct {
+	MPQData           MPQ
+	BlockTableEntry   BlockTableEntry
+	FileName          string
+	EncryptionSeed    uint32
+	BlockPositions    []uint32
+	CurrentPosition   uint32
+	CurrentData       []byte
+	CurrentBlockIndex uint32
+	BlockSize         uint32
+	// Index of the block table entry of the file, identifying its sectors in
+	// the sector cache; -1 if unknown, in which case sectors are not cached.
+	blockIndex int64
+}
+
+// CreateStream creates an MPQ stream
+func CreateStream(mpq MPQ, blockTableEntry BlockTableEntry, fileName string) (*Stream, error) {
+	result := &Stream{
+		MPQData:           mpq,
+		BlockTableEntry:   blockTableEntry,
+		CurrentBlockIndex: 0xFFFFFFFF,
+		blockIndex:        -1,
+	}
+	result.EncryptionSeed = FileKey(fileName)
+	if result.BlockTableEntry.HasFlag(FileFixKey) {
+		result.EncryptionSeed = (result.EncryptionSeed + result.BlockTableEntry.FilePosition) ^ result.BlockTableEntry.UncompressedFileSize
+	}
+	result.BlockSize = 0x200 << result.MPQData.Data.BlockSize
+
+	if result.BlockTableEntry.HasFlag(FilePatchFile) {
+		return nil, fmt.Errorf("unable to read %q; patch files are not supported", fileName)
+	}
+
+	if (result.BlockTableEntry.HasFlag(FileCompress) || result.BlockTableEntry.HasFlag(FileImplode)) && !result.BlockTableEntry.HasFlag(FileSingleUnit) {
+		if err := result.loadBlockOffsets(); err != nil {
+			return nil, fmt.Errorf("unable to read %q; %w", fileName, err)
+		}
+	}
+	return result, nil
+}
+
+func (v *Stream) loadBlockOffsets() error {
+	if v.BlockTableEntry.UncompressedFileSize == 0 {
+		// Empty files have no sectors.
+		return nil
+	}
+	blockPositionCount := ((v.BlockTableEntry.UncompressedFileSize + v.BlockSize - 1) / v.BlockSize) + 1
+	blockPosSize := blockPositionCount << 2
+	if blockPosSize > v.BlockTableEntry.CompressedFileSize {
+		return fmt.Errorf("sector offset table of %d bytes exceeds compressed size of %d bytes", blockPosSize, v.BlockTableEntry.CompressedFileSize)
+	}
+	v.BlockPositions = make([]uint32, blockPositionCount)
+	if _, err := v.MPQData.File.Seek(v.BlockTableEntry.Position(), io.SeekStart); err != nil {
+		return fmt.Errorf("unable to read sector offset table; %w", err)
+	}
+	bytes := make([]byte, blockPosSize)
+	if _, err := io.ReadFull(v.MPQData.File, bytes); err != nil {
+		return fmt.Errorf("unable to read sector offset table; %w", err)
+	}
+	for i := range v.BlockPositions {
+		idx := i * 4
+		v.BlockPositions[i] = binary.LittleEndian.Uint32(bytes[idx : idx+4])
+	}
+	//binary.Read(v.MPQData.File, binary.LittleEndian, &v.BlockPositions)
+	if v.BlockTableEntry.HasFlag(FileEncrypted) {
+		decrypt(v.BlockPositions, v.EncryptionSeed-1)
+		if v.BlockPositions[0] != blockPosSize {
+			return fmt.Errorf("decryption of sector offset table failed; first offset is 0x%X, expected 0x%X", v.BlockPositions[0], blockPosSize)
+		}
+		if len(v.BlockPositions) > 1 && v.BlockPositions[1] > v.BlockSize+blockPosSize {
+			return fmt.Errorf("decryption of sector offset table failed; second offset 0x%X exceeds 0x%X", v.BlockPositions[1], v.BlockSize+blockPosSize)
+		}
+	}
+	for i := 1; i < len(v.BlockPositions); i++ {
+		if v.BlockPositions[i] < v.BlockPositions[i-1] {
+			return fmt.Errorf("bad offset of sector %d; 0x%X precedes offset 0x%X of previous sector", i, v.BlockPositions[i], v.BlockPositions[i-1])
+		}
+	}
+	// Offsets are increasing; bounding the last offset by the compressed size
+	// bounds the size of each sector.
+	if last := v.BlockPositions[len(v.BlockPositions)-1]; last > v.BlockTableEntry.CompressedFileSize {
+		return fmt.Errorf("bad sector offset 0x%X; exceeds compressed size of %d bytes", last, v.BlockTableEntry.CompressedFileSize)
+	}
+	return nil
+}
+
+// Read reads count bytes of the decompressed file contents at the current
+// position into buffer at the given offset, and returns the number of bytes
+// read.
+func (v *Stream) Read(buffer []byte, offset, count uint32) (uint32, error) {
+	if v.BlockTableEntry.HasFlag(FileSingleUnit) {
+		return v.readInternalSingleUnit(buffer, offset, count)
+	}
+	toRead := count
+	readTotal := uint32(0)
+	for toRead > 0 {
+		read, err := v.readInternal(buffer, offset, toRead)
+		if err != nil {
+			return readTotal, err
+		}
+		if read == 0 {
+			break
+		}
+		readTotal += read
+		offset += read
+		toRead -= read
+	}
+	return readTotal, nil
+}
+
+func (v *Stream) readInternalSingleUnit(buffer []byte, offset, count uint32) (uint32, error) {
+	if len(v.CurrentData) == 0 {
+		if err := v.loadSingleUnit(); err != nil {
+			return 0, err
+		}
+	}
+
+	bytesToCopy := d2helper.Min(uint32(len(v.CurrentData))-v.CurrentPosition, count)
+	copy(buffer[offset:offset+bytesToCopy], v.CurrentData[v.CurrentPosition:v.CurrentPosition+bytesToCopy])
+	v.CurrentPosition += bytesToCopy
+	return bytesToCopy, nil
+}
+
+func (v *Stream) readInternal(buffer []byte, offset, count uint32) (uint32, error) {
+	if err := v.bufferData(); err != nil {
+		return 0, err
+	}
+	localPosition := v.CurrentPosition % v.BlockSize
+	bytesToCopy := d2helper.MinInt32(int32(len(v.CurrentData))-int32(localPosition), int32(count))
+	if bytesToCopy <= 0 {
+		return 0, nil
+	}
+	copy(buffer[offset:offset+uint32(bytesToCopy)], v.CurrentData[localPosition:localPosition+uint32(bytesToCopy)])
+	v.CurrentPosition += uint32(bytesToCopy)
+	return uint32(bytesToCopy), nil
+}
+
+func (v *Stream) bufferData() error {
+	requiredBlock := uint32(v.CurrentPosition / v.BlockSize)
+	if requiredBlock == v.CurrentBlockIndex {
+		return nil
+	}
+	expectedLength := d2helper.Min(v.BlockTableEntry.UncompressedFileSize-(requiredBlock*v.BlockSize), v.BlockSize)
+	data, err := v.loadBlock(requiredBlock, expectedLength)
+	if err != nil {
+		return fmt.Errorf("unable to read sector %d of %q; %w", requiredBlock, v.BlockTableEntry.FileName, err)
+	}
+	v.CurrentData = data
+	v.CurrentBlockIndex = requiredBlock
+	return nil
+}
+
+func (v *Stream) loadSingleUnit() error {
+	fileData := make([]byte, v.BlockTableEntry.CompressedFileSize)
+	if _, err := v.MPQData.File.Seek(v.BlockTableEntry.Position(), io.SeekStart); err != nil {
+		return fmt.Errorf("unable to read single unit file %q; %w", v.BlockTableEntry.FileName, err)
+	}
+	if _, err := io.ReadFull(v.MPQData.File, fileData); err != nil {
+		return fmt.Errorf("unable to read single unit file %q; %w", v.BlockTableEntry.FileName, err)
+	}
+	if v.BlockTableEntry.HasFlag(FileEncrypted) {
+		if v.EncryptionSeed == 0 {
+			return fmt.Errorf("unable to determine encryption key of %q", v.BlockTableEntry.FileName)
+		}
+		decryptBytes(fileData, v.EncryptionSeed)
+	}
+	if v.BlockTableEntry.CompressedFileSize >= v.BlockTableEntry.UncompressedFileSize {
+		v.CurrentData = fileData
+		return nil
+	}
+	data, err := v.decompress(fileData, v.BlockTableEntry.UncompressedFileSize)
+	if err != nil {
+		return fmt.Errorf("unable to decompress single unit file %q; %w", v.BlockTableEntry.FileName, err)
+	}
+	v.CurrentData = data
+	return nil
+}
+
+func (v *Stream) loadBlock(blockIndex, expectedLength uint32) ([]byte, error) {
+	cache := v.MPQData.sectors
+	if v.blockIndex < 0 {
+		cache = nil
+	}
+	key := sectorKey{block: uint32(v.blockIndex), sector: blockIndex}
+	if cache != nil {
+		if data, ok := cache.Get(key); ok {
+			return data, nil
+		}
+	}
+	data, err := v.readBlock(blockIndex, expectedLength)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.Put(key, data)
+	}
+	return data, nil
+}
+
+// readBlock reads and decompresses the given sector of the file.
+func (v *Stream) readBlock(blockIndex, expectedLength uint32) ([]byte, error) {
+	var (
+		offset int64
+		toRead uint32
+	)
+	if v.BlockTableEntry.HasFlag(FileCompress) || v.BlockTableEntry.HasFlag(FileImplode) {
+		if int(blockIndex)+1 >= len(v.BlockPositions) {
+			return nil, fmt.Errorf("bad sector offset; sector offset table has %d entries", len(v.BlockPositions))
+		}
+		offset = int64(v.BlockPositions[blockIndex])
+		toRead = v.BlockPositions[blockIndex+1] - v.BlockPositions[blockIndex]
+	} else {
+		offset = int64(blockIndex) * int64(v.BlockSize)
+		toRead = expectedLength
+	}
+	offset += v.BlockTableEntry.Position()
+	data := make([]byte, toRead)
+	if _, err := v.MPQData.File.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("bad sector offset 0x%X; %w", offset, err)
+	}
+	if _, err := io.ReadFull(v.MPQData.File, data); err != nil {
+		return nil, fmt.Errorf("bad sector offset 0x%X; %w", offset, err)
+	}
+	if v.BlockTableEntry.HasFlag(FileEncrypted) && v.BlockTableEntry.UncompressedFileSize > 3 {
+		if v.EncryptionSeed == 0 {
+			return nil, errors.New("unable to determine encryption key")
+		}
+
+		decryptBytes(data, blockIndex+v.EncryptionSeed)
+	}
+	if toRead != expectedLength {
+		return v.decompress(data, expectedLength)
+	}
+	return data, nil
+}
+
+// decompress decompresses the given sector (or single unit file) according to
+// the block flags. The FileCompress and FileImplode flags are mutually
+// exclusive, and select entirely different code paths; FileCompress denotes
+// sectors prefixed by a compression mask, decompressed using one or more
+// methods, whereas FileImplode denotes sectors compressed using PKWARE DCL
+// implode without any compression mask. As done by Storm, FileCompress takes
+// precedence should both flags be set.
+func (v *Stream) decompress(data []byte, expectedLength uint32) ([]byte, error) {
+	switch {
+	case v.BlockTableEntry.HasFlag(FileCompress):
+		return decompressMulti(data, expectedLength)
+	case v.BlockTableEntry.HasFlag(FileImplode):
+		return pkDecompress(data)
+	}
+	return data, nil
+}
+
+// Compression masks of sectors of files with the FileCompress flag.
+const (
+	CompressionHuffman     = 0x01
+	CompressionZlib        = 0x02
+	CompressionPKLib       = 0x08
+	CompressionBZip2       = 0x10
+	CompressionSparse      = 0x20
+	CompressionADPCMMono   = 0x40
+	CompressionADPCMStereo = 0x80
+	// LZMA is not a combination of methods; it is exclusive of all others.
+	CompressionLZMA = 0x12
+)
+
+// sectorDecompressors lists the decompression methods of sectors, in the order
+// in which they are applied to sectors compressed using multiple methods; the
+// reverse of the order of compression.
+var sectorDecompressors = []struct {
+	mask byte
+	name string
+	// decompress is nil for sparse compression, which is bounded by the
+	// expected length of the sector; see decompressMulti.
+	decompress func(data []byte) ([]byte, error)
+}{
+	{CompressionBZip2, "bzip2", bzip2Decompress},
+	{CompressionPKLib, "pklib", pkDecompress},
+	{CompressionZlib, "zlib", deflate},
+	{CompressionHuffman, "huffman", huffmanDecompress},
+	{CompressionADPCMStereo, "adpcm stereo", func(data []byte) ([]byte, error) { return wavDecompress(data, 2) }},
+	{CompressionADPCMMono, "adpcm mono", func(data []byte) ([]byte, error) { return wavDecompress(data, 1) }},
+	{CompressionSparse, "sparse", nil},
+}
+
+// decompressMulti decompresses the given sector, which is prefixed by a
+// compression mask of one or more methods. Each method of the mask is undone
+// in turn, as ordered by sectorDecompressors.
+func decompressMulti(data []byte, expectedLength uint32) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty compressed sector")
+	}
+	compressionType := data[0]
+	if compressionType == CompressionLZMA {
+		return nil, errors.New("lzma decompression not supported")
+	}
+	known := byte(0)
+	for _, d := range sectorDecompressors {
+		known |= d.mask
+	}
+	if compressionType&^known != 0 {
+		return nil, fmt.Errorf("decompression not supported for unknown compression type %X", compressionType)
+	}
+	data = data[1:]
+	for _, d := range sectorDecompressors {
+		if compressionType&d.mask != 0 {
+			var err error
+			if d.mask == CompressionSparse {
+				data, err = sparseDecompress(data, expectedLength)
+			} else {
+				data, err = d.decompress(data)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s decompression failed; %w", d.name, err)
+			}
+		}
+	}
+	return data, nil
+}
+
+// huffmanDecompress decompresses the given Huffman compressed data. The decoder
+// of OpenDiablo2 panics on malformed input, which is reported as an error.
+func huffmanDecompress(data []byte) (out []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			out, err = nil, fmt.Errorf("malformed input; %v", e)
+		}
+	}()
+	return d2compression.HuffmanDecompress(data), nil
+}
+
+// wavDecompress decompresses the given IMA ADPCM compressed audio of the
+// specified number of channels. The result is copied, as it may alias the
+// internal buffers of the decoder. The decoder of OpenDiablo2 panics on
+// malformed input, which is reported as an error.
+func wavDecompress(data []byte, channelCount int) (out []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			out, err = nil, fmt.Errorf("malformed input; %v", e)
+		}
+	}()
+	sinput := d2compression.WavDecompress(data, channelCount)
+	tmp := make([]byte, len(sinput))
+	copy(tmp, sinput)
+	return tmp, nil
+}
+
+func deflate(data []byte) ([]byte, error) {
+	b := bytes.NewReader(data)
+	r, err := zlib.NewReader(b)
+	if err != nil {
+		return nil, err
+	}
+	buffer := new(bytes.Buffer)
+	_, err = buffer.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	err = r.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func bzip2Decompress(data []byte) ([]byte, error) {
+	r := bzip2.NewReader(bytes.NewReader(data))
+	buffer := new(bytes.Buffer)
+	_, err := buffer.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func pkDecompress(data []byte) ([]byte, error) {
+	b := bytes.NewReader(data)
+	r, err := blast.NewReader(b)
+	if err != nil {
+		return nil, err
+	}
+	buffer := new(bytes.Buffer)
+	_, err = buffer.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	err = r.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,431 @@
+package d2mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OpenDiablo2/MpqViewer/internal/mpqtest"
+)
+
+// corruptArchive writes the given MPQ archive to dir after applying corrupt to
+// its contents, and loads it. The offset of the MPQ header and the position of
+// the block of the given file are passed to corrupt.
+func corruptArchive(t *testing.T, dir string, a mpqtest.Archive, fileName string, corrupt func(data []byte, blockPos int64)) *MPQ {
+	t.Helper()
+	good, err := Load(a.Write(t, dir, "good.mpq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, ok := good.FileBlock(fileName)
+	if !ok {
+		t.Fatalf("file %q not found", fileName)
+	}
+	data := a.Bytes()
+	corrupt(data, block.Position())
+	mpqPath := filepath.Join(dir, "corrupt.mpq")
+	if err := ioutil.WriteFile(mpqPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := Load(mpqPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestReadEncrypted(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	golden := []struct {
+		name string
+		file mpqtest.File
+	}{
+		{name: "compressed", file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib, Encrypted: true}},
+		{name: "compressed fix key", file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib, Encrypted: true, FixKey: true}},
+		{name: "uncompressed", file: mpqtest.File{Name: booksPath, Data: books, Encrypted: true}},
+		{name: "single unit", file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib, Encrypted: true, SingleUnit: true}},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: []mpqtest.File{g.file}}
+			archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := archive.ReadFile(booksPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, books) {
+				t.Errorf("contents mismatch; expected %d bytes, got %d bytes", len(books), len(got))
+			}
+		})
+	}
+}
+
+func TestReadMalformedSectorTable(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	golden := []struct {
+		name    string
+		file    mpqtest.File
+		corrupt func(data []byte, blockPos int64)
+		wantErr string
+	}{
+		{
+			name: "encrypted garbage",
+			file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib, Encrypted: true},
+			corrupt: func(data []byte, blockPos int64) {
+				binary.LittleEndian.PutUint32(data[blockPos:], 0xDEADBEEF)
+			},
+			wantErr: "decryption of sector offset table failed",
+		},
+		{
+			name: "decreasing offsets",
+			file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib},
+			corrupt: func(data []byte, blockPos int64) {
+				binary.LittleEndian.PutUint32(data[blockPos+8:], 0)
+			},
+			wantErr: "precedes offset",
+		},
+		{
+			name: "offset beyond end of file",
+			file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib},
+			corrupt: func(data []byte, blockPos int64) {
+				// End offset of the last sector.
+				sectorCount := (len(books) + 0x1FF) / 0x200
+				binary.LittleEndian.PutUint32(data[blockPos+4*int64(sectorCount):], 0x7FFFFFFF)
+			},
+			wantErr: "bad sector offset",
+		},
+		{
+			name:    "sector offset table beyond block",
+			file:    mpqtest.File{Name: booksPath, Data: books, Size: 1 << 30, Compression: mpqtest.CompressionZlib},
+			corrupt: func(data []byte, blockPos int64) {},
+			wantErr: "exceeds compressed size",
+		},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: []mpqtest.File{g.file}}
+			archive := corruptArchive(t, t.TempDir(), a, booksPath, g.corrupt)
+			_, err := archive.ReadFile(booksPath)
+			if err == nil {
+				t.Fatalf("expected error containing %q, got nil", g.wantErr)
+			}
+			if !strings.Contains(err.Error(), g.wantErr) {
+				t.Errorf("error mismatch; expected error containing %q, got %q", g.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestReadCompressionFlags(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	golden := []struct {
+		name      string
+		file      mpqtest.File
+		wantFlags FileFlag
+	}{
+		// FileImplode; sectors imploded without compression mask.
+		{name: "implode", file: mpqtest.File{Name: booksPath, Data: books, Implode: true}, wantFlags: FileImplode},
+		{name: "implode single unit", file: mpqtest.File{Name: booksPath, Data: books, Implode: true, SingleUnit: true}, wantFlags: FileImplode | FileSingleUnit},
+		{name: "implode encrypted", file: mpqtest.File{Name: booksPath, Data: books, Implode: true, Encrypted: true}, wantFlags: FileImplode | FileEncrypted},
+		// FileCompress; sectors prefixed by compression mask.
+		{name: "compress pklib", file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionPKLib}, wantFlags: FileCompress},
+		{name: "compress zlib", file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib}, wantFlags: FileCompress},
+		{name: "compress single unit", file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib, SingleUnit: true}, wantFlags: FileCompress | FileSingleUnit},
+		// Both; FileCompress takes precedence, as done by Storm.
+		{name: "both", file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib, Implode: true}, wantFlags: FileCompress | FileImplode},
+		// Neither; stored uncompressed.
+		{name: "stored", file: mpqtest.File{Name: booksPath, Data: books}, wantFlags: 0},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: []mpqtest.File{g.file}}
+			archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			block, ok := archive.FileBlock(booksPath)
+			if !ok {
+				t.Fatalf("file %q not found", booksPath)
+			}
+			const mask = FileImplode | FileCompress | FileSingleUnit | FileEncrypted
+			if got := block.Flags & mask; got != g.wantFlags {
+				t.Errorf("flags mismatch; expected 0x%08X, got 0x%08X", g.wantFlags, got)
+			}
+			if g.wantFlags&(FileImplode|FileCompress) != 0 && block.CompressedFileSize >= block.UncompressedFileSize {
+				t.Errorf("file not compressed; compressed size %d, uncompressed size %d", block.CompressedFileSize, block.UncompressedFileSize)
+			}
+			got, err := archive.ReadFile(booksPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, books) {
+				t.Errorf("contents mismatch; expected %d bytes, got %d bytes", len(books), len(got))
+			}
+		})
+	}
+}
+
+func TestReadSparse(t *testing.T) {
+	const sparsePath = `data\global\sparse.bin`
+	// Runs of zero bytes interleaved with text, spanning several sectors.
+	var data []byte
+	for i := 0; i < 64; i++ {
+		data = append(data, books[:100]...)
+		data = append(data, make([]byte, 300+i)...)
+	}
+	golden := []struct {
+		name string
+		file mpqtest.File
+	}{
+		{name: "sparse", file: mpqtest.File{Name: sparsePath, Data: data, Compression: mpqtest.CompressionSparse}},
+		{name: "sparse zlib", file: mpqtest.File{Name: sparsePath, Data: data, Compression: mpqtest.CompressionSparse | mpqtest.CompressionZlib}},
+		{name: "sparse single unit", file: mpqtest.File{Name: sparsePath, Data: data, Compression: mpqtest.CompressionSparse, SingleUnit: true}},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: []mpqtest.File{g.file}}
+			archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			block, ok := archive.FileBlock(sparsePath)
+			if !ok {
+				t.Fatalf("file %q not found", sparsePath)
+			}
+			if block.CompressedFileSize >= block.UncompressedFileSize {
+				t.Errorf("file not compressed; compressed size %d, uncompressed size %d", block.CompressedFileSize, block.UncompressedFileSize)
+			}
+			got, err := archive.ReadFile(sparsePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("contents mismatch; expected %d bytes, got %d bytes", len(data), len(got))
+			}
+		})
+	}
+}
+
+func TestReadSparseOversize(t *testing.T) {
+	const sparsePath = `data\global\sparse.bin`
+	data := append(append([]byte(nil), books[:100]...), make([]byte, 1000)...)
+	a := mpqtest.Archive{Files: []mpqtest.File{
+		{Name: sparsePath, Data: data, Compression: mpqtest.CompressionSparse, SingleUnit: true},
+	}}
+	// Record a decompressed size of 4 GB in the sparse data, which follows the
+	// compression mask.
+	archive := corruptArchive(t, t.TempDir(), a, sparsePath, func(data []byte, blockPos int64) {
+		binary.BigEndian.PutUint32(data[blockPos+1:], 0xFFFFFFFF)
+	})
+	_, err := archive.ReadFile(sparsePath)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if want := "exceeds expected size"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error mismatch; expected %q, got %q", want, err)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	// The recorded size exceeds the length of the file contents, within the
+	// first sector.
+	data := books[:300]
+	golden := []struct {
+		name string
+		file mpqtest.File
+	}{
+		{name: "compressed", file: mpqtest.File{Name: booksPath, Data: data, Size: 400, Compression: mpqtest.CompressionZlib}},
+		{name: "single unit", file: mpqtest.File{Name: booksPath, Data: data, Size: 400, Compression: mpqtest.CompressionZlib, SingleUnit: true}},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: []mpqtest.File{g.file}}
+			archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = archive.ReadFile(booksPath)
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("error mismatch; expected %v, got %v", io.ErrUnexpectedEOF, err)
+			}
+		})
+	}
+}
+
+// TestReadBZip2 reads the files of testdata/bzip2.mpq, of which each sector is
+// compressed using BZip2 (compression mask 0x10), and compares them against the
+// reference files of testdata/bzip2. The MPQ archive was created using the bz2
+// module of Python, as Go provides no BZip2 compressor.
+func TestReadBZip2(t *testing.T) {
+	archive, err := Load(filepath.Join("testdata", "bzip2.mpq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := []struct {
+		fileName string
+		refPath  string
+	}{
+		{fileName: `data\global\excel\books.txt`, refPath: "books.txt"},
+		{fileName: `data\global\palette\pal.dat`, refPath: "pal.dat"},
+	}
+	for _, g := range golden {
+		t.Run(g.refPath, func(t *testing.T) {
+			want, err := ioutil.ReadFile(filepath.Join("testdata", "bzip2", g.refPath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			block, ok := archive.FileBlock(g.fileName)
+			if !ok {
+				t.Fatalf("file %q not found", g.fileName)
+			}
+			if !block.HasFlag(FileCompress) || block.CompressedFileSize >= block.UncompressedFileSize {
+				t.Errorf("file not compressed; flags 0x%08X, compressed size %d, uncompressed size %d", uint32(block.Flags), block.CompressedFileSize, block.UncompressedFileSize)
+			}
+			got, err := archive.ReadFile(g.fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("contents mismatch; expected %d bytes, got %d bytes", len(want), len(got))
+			}
+		})
+	}
+}
+
+func TestReadMultiCompression(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	golden := []struct {
+		name string
+		file mpqtest.File
+	}{
+		// Compressed using zlib, then PKWARE DCL; decompressed in reverse.
+		{name: "pklib zlib", file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionPKLib | mpqtest.CompressionZlib}},
+		{name: "pklib zlib single unit", file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionPKLib | mpqtest.CompressionZlib, SingleUnit: true}},
+		{name: "pklib zlib encrypted", file: mpqtest.File{Name: booksPath, Data: books, Compression: mpqtest.CompressionPKLib | mpqtest.CompressionZlib, Encrypted: true}},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: []mpqtest.File{g.file}}
+			archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			block, ok := archive.FileBlock(booksPath)
+			if !ok {
+				t.Fatalf("file %q not found", booksPath)
+			}
+			if !g.file.Encrypted {
+				// Compression mask of the first sector, following the sector
+				// offset table unless stored as a single unit.
+				data := a.Bytes()
+				pos := block.Position()
+				if !g.file.SingleUnit {
+					pos += int64(binary.LittleEndian.Uint32(data[pos:]))
+				}
+				if want := byte(CompressionPKLib | CompressionZlib); data[pos] != want {
+					t.Errorf("compression mask mismatch; expected 0x%02X, got 0x%02X", want, data[pos])
+				}
+			}
+			got, err := archive.ReadFile(booksPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, books) {
+				t.Errorf("contents mismatch; expected %d bytes, got %d bytes", len(books), len(got))
+			}
+		})
+	}
+}
+
+func TestSectorCache(t *testing.T) {
+	const (
+		booksPath = `data\global\excel\books.txt`
+		otherPath = `data\global\excel\other.txt`
+	)
+	other := bytes.ToUpper(books)
+	a := mpqtest.Archive{Files: []mpqtest.File{
+		{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib},
+		{Name: otherPath, Data: other, Compression: mpqtest.CompressionZlib, Encrypted: true},
+	}}
+	archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive.SetSectorCacheSize(1 << 20)
+	golden := []struct {
+		fileName string
+		want     []byte
+		// Index of the block table entry of the file.
+		block uint32
+	}{
+		{fileName: booksPath, want: books, block: 0},
+		{fileName: otherPath, want: other, block: 1},
+	}
+	// Read each file twice; the second read is served from the sector cache.
+	for i := 0; i < 2; i++ {
+		for _, g := range golden {
+			got, err := archive.ReadFile(g.fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, g.want) {
+				t.Errorf("%q: contents mismatch; expected %d bytes, got %d bytes", g.fileName, len(g.want), len(got))
+			}
+			if _, ok := archive.sectors.Get(sectorKey{block: g.block, sector: 0}); !ok {
+				t.Errorf("%q: first sector of block %d not cached", g.fileName, g.block)
+			}
+		}
+	}
+}
+
+func TestReadEmptyEncrypted(t *testing.T) {
+	const emptyPath = `data\global\empty.txt`
+	a := mpqtest.Archive{Files: []mpqtest.File{
+		{Name: emptyPath, Data: []byte{}, Compression: mpqtest.CompressionZlib, Encrypted: true},
+	}}
+	archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, ok := archive.FileBlock(emptyPath)
+	if !ok {
+		t.Fatalf("file %q not found", emptyPath)
+	}
+	const mask = FileCompress | FileEncrypted | FileSingleUnit
+	if want := FileCompress | FileEncrypted; block.Flags&mask != want {
+		t.Fatalf("flags mismatch; expected 0x%08X, got 0x%08X", uint32(want), uint32(block.Flags&mask))
+	}
+	got, err := archive.ReadFile(emptyPath)
+	if err != nil {
+		t.Fatalf("unexpected error; %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("contents mismatch; expected 0 bytes, got %d bytes", len(got))
+	}
+}
+
+func TestReadBadBlockIndex(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	a := mpqtest.Archive{Files: []mpqtest.File{{Name: booksPath, Data: books}}}
+	archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range archive.HashTableEntries {
+		if archive.HashTableEntries[i].BlockIndex == 0 {
+			archive.HashTableEntries[i].BlockIndex = 100
+		}
+	}
+	if _, err := archive.ReadFile(booksPath); err == nil || !strings.Contains(err.Error(), "exceeds block table") {
+		t.Errorf("error mismatch; expected error containing %q, got %v", "exceeds block table", err)
+	}
+}
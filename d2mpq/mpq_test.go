@@ -0,0 +1,421 @@
+package d2mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/OpenDiablo2/MpqViewer/internal/mpqtest"
+)
+
+func TestMain(m *testing.M) {
+	InitializeCryptoBuffer()
+	os.Exit(m.Run())
+}
+
+// books is the contents of a test file spanning multiple sectors.
+var books = bytes.Repeat([]byte("Name\tNamco\tCompleted\n"), 100)
+
+func TestCryptoUninitialized(t *testing.T) {
+	a := mpqtest.Archive{
+		Files: []mpqtest.File{{Name: `data\global\excel\books.txt`, Data: books, Compression: mpqtest.CompressionZlib}},
+	}
+	mpqPath := a.Write(t, t.TempDir(), "d2data.mpq")
+	saved := CryptoBuffer
+	defer func() { CryptoBuffer = saved }()
+
+	// Load before initialization.
+	CryptoBuffer = [len(CryptoBuffer)]uint32{}
+	if _, err := Load(mpqPath); err != ErrCryptoUninitialized {
+		t.Errorf("Load: expected %v, got %v", ErrCryptoUninitialized, err)
+	}
+	if _, err := LoadProtected(mpqPath); err != ErrCryptoUninitialized {
+		t.Errorf("LoadProtected: expected %v, got %v", ErrCryptoUninitialized, err)
+	}
+
+	// Read after the crypto buffer was reset (e.g. by a library consumer).
+	CryptoBuffer = saved
+	archive, err := Load(mpqPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CryptoBuffer = [len(CryptoBuffer)]uint32{}
+	if _, err := archive.ReadFile(`data\global\excel\books.txt`); err != ErrCryptoUninitialized {
+		t.Errorf("ReadFile: expected %v, got %v", ErrCryptoUninitialized, err)
+	}
+	if _, err := archive.OpenFile(`data\global\excel\books.txt`); err != ErrCryptoUninitialized {
+		t.Errorf("OpenFile: expected %v, got %v", ErrCryptoUninitialized, err)
+	}
+
+	CryptoBuffer = saved
+	got, err := archive.ReadFile(`data\global\excel\books.txt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, books) {
+		t.Errorf("contents mismatch; expected %q, got %q", books, got)
+	}
+}
+
+func TestTablePositions(t *testing.T) {
+	golden := []struct {
+		name      string
+		mpq       MPQ
+		wantHash  int64
+		wantBlock int64
+	}{
+		{
+			name:      "v1",
+			mpq:       MPQ{Data: Data{HashTableOffset: 0x1000, BlockTableOffset: 0x2000}},
+			wantHash:  0x1000,
+			wantBlock: 0x2000,
+		},
+		{
+			name:      "v2 high bits",
+			mpq:       MPQ{Data: Data{HashTableOffset: 0x1000, BlockTableOffset: 0x2000}, DataV2: DataV2{HashTableOffsetHi: 0x1, BlockTableOffsetHi: 0x2}},
+			wantHash:  0x1_0000_1000,
+			wantBlock: 0x2_0000_2000,
+		},
+		{
+			name:      "header offset",
+			mpq:       MPQ{Data: Data{HashTableOffset: 0x1000, BlockTableOffset: 0x2000}, DataV2: DataV2{HashTableOffsetHi: 0x1}, HeaderOffset: 0x200},
+			wantHash:  0x1_0000_1200,
+			wantBlock: 0x2200,
+		},
+	}
+	for _, g := range golden {
+		if got := g.mpq.HashTablePosition(); got != g.wantHash {
+			t.Errorf("%s: hash table position mismatch; expected 0x%X, got 0x%X", g.name, g.wantHash, got)
+		}
+		if got := g.mpq.BlockTablePosition(); got != g.wantBlock {
+			t.Errorf("%s: block table position mismatch; expected 0x%X, got 0x%X", g.name, g.wantBlock, got)
+		}
+	}
+}
+
+func TestLoadFormatVersion(t *testing.T) {
+	golden := []struct {
+		name    string
+		v2      bool
+		file    mpqtest.File
+		version uint16
+		// Expected high 16 bits of the file position.
+		wantHi uint16
+	}{
+		// Format version 1 archives are unaffected by the extended header.
+		{name: "v1", v2: false, file: mpqtest.File{Name: `data\global\excel\books.txt`, Data: books, Compression: mpqtest.CompressionZlib}, version: FormatVersion1, wantHi: 0},
+		{name: "v1 encrypted", v2: false, file: mpqtest.File{Name: `data\global\excel\books.txt`, Data: books, Compression: mpqtest.CompressionZlib, Encrypted: true}, version: FormatVersion1, wantHi: 0},
+		{name: "v2 low offset", v2: true, file: mpqtest.File{Name: `data\global\excel\books.txt`, Data: books, Compression: mpqtest.CompressionZlib}, version: FormatVersion2, wantHi: 0},
+		{name: "v2 high offset", v2: true, file: mpqtest.File{Name: `data\global\excel\books.txt`, Data: books, Compression: mpqtest.CompressionZlib, PositionHi: 0x1}, version: FormatVersion2, wantHi: 0x1},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: []mpqtest.File{g.file}, V2: g.v2}
+			archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if archive.Data.FormatVersion != g.version {
+				t.Errorf("format version mismatch; expected %d, got %d", g.version, archive.Data.FormatVersion)
+			}
+			if !g.v2 && archive.DataV2 != (DataV2{}) {
+				t.Errorf("extended header of format version 1 archive; got %+v", archive.DataV2)
+			}
+			block, ok := archive.FileBlock(g.file.Name)
+			if !ok {
+				t.Fatalf("file %q not found", g.file.Name)
+			}
+			if block.FilePositionHi != g.wantHi {
+				t.Errorf("hi-block table entry mismatch; expected 0x%X, got 0x%X", g.wantHi, block.FilePositionHi)
+			}
+			if want := int64(g.wantHi)<<32 | int64(block.FilePosition); block.Position() != want {
+				t.Errorf("file position mismatch; expected 0x%X, got 0x%X", want, block.Position())
+			}
+			if g.wantHi != 0 {
+				// The contents of files beyond 4 GB are not present in the test
+				// archive.
+				return
+			}
+			got, err := archive.ReadFile(g.file.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, g.file.Data) {
+				t.Errorf("contents mismatch; expected %d bytes, got %d bytes", len(g.file.Data), len(got))
+			}
+		})
+	}
+}
+
+// TestGetFileListConcurrent calls GetFileList from many goroutines before the
+// (listfile) has been parsed; run with -race to detect data races.
+func TestGetFileListConcurrent(t *testing.T) {
+	a := mpqtest.Archive{
+		Files: []mpqtest.File{
+			{Name: `data\global\excel\books.txt`, Data: books, Compression: mpqtest.CompressionZlib},
+			{Name: `data\global\excel\charstats.txt`, Data: books, Compression: mpqtest.CompressionZlib},
+		},
+		Listfile: true,
+	}
+	archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`data\global\excel\books.txt`, `data\global\excel\charstats.txt`}
+	const n = 64
+	results := make([][]string, n)
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			results[i], errs[i] = archive.GetFileList()
+			// Callers own the returned slice.
+			if len(results[i]) > 0 {
+				results[i][0] = strings.ToUpper(results[i][0])
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: unexpected error; %v", i, errs[i])
+		}
+		got := append([]string{strings.ToLower(results[i][0])}, results[i][1:]...)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("caller %d: file list mismatch; expected %q, got %q", i, want, results[i])
+		}
+	}
+	got, err := archive.GetFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("file list modified by callers; expected %q, got %q", want, got)
+	}
+}
+
+func TestGetFileListEncrypted(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	listfile := []byte(booksPath + "\r\n")
+	golden := []struct {
+		name     string
+		listfile mpqtest.File
+	}{
+		{name: "plain", listfile: mpqtest.File{Name: "(listfile)", Data: listfile, Compression: mpqtest.CompressionZlib}},
+		{name: "encrypted", listfile: mpqtest.File{Name: "(listfile)", Data: listfile, Compression: mpqtest.CompressionZlib, Encrypted: true}},
+		{name: "encrypted fix key", listfile: mpqtest.File{Name: "(listfile)", Data: listfile, Compression: mpqtest.CompressionZlib, Encrypted: true, FixKey: true}},
+		{name: "encrypted uncompressed", listfile: mpqtest.File{Name: "(listfile)", Data: listfile, Encrypted: true}},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{
+				Files: []mpqtest.File{
+					{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib},
+					g.listfile,
+				},
+			}
+			archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := archive.GetFileList()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{booksPath}; !reflect.DeepEqual(got, want) {
+				t.Errorf("file list mismatch; expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestLoadDataFork(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	fork := bytes.Repeat([]byte("data fork "), 100)
+	golden := []struct {
+		name    string
+		archive mpqtest.Archive
+		// Expected offset of the MPQ header.
+		wantOffset int64
+	}{
+		{name: "no fork", archive: mpqtest.Archive{}, wantOffset: 0},
+		// MPQ header at the header alignment following the fork.
+		{name: "fork", archive: mpqtest.Archive{Prefix: fork}, wantOffset: 0x400},
+		// User-data header at the header alignment following the fork, which
+		// records the offset of the MPQ header.
+		{name: "fork user data", archive: mpqtest.Archive{Prefix: fork, UserData: true}, wantOffset: 0x600},
+		{name: "user data", archive: mpqtest.Archive{UserData: true}, wantOffset: 0x200},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := g.archive
+			a.Files = []mpqtest.File{
+				{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib, Encrypted: true, FixKey: true},
+				{Name: `data\global\excel\charstats.txt`, Data: books, SingleUnit: true, Compression: mpqtest.CompressionZlib},
+			}
+			archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if archive.HeaderOffset != g.wantOffset {
+				t.Errorf("header offset mismatch; expected 0x%X, got 0x%X", g.wantOffset, archive.HeaderOffset)
+			}
+			if wantUserData := g.archive.UserData; (string(archive.UserData.Magic[:]) == userDataMagic) != wantUserData {
+				t.Errorf("user-data header mismatch; expected present %v, got %+v", wantUserData, archive.UserData)
+			}
+			for _, file := range a.Files {
+				got, err := archive.ReadFile(file.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, file.Data) {
+					t.Errorf("%q: contents mismatch; expected %d bytes, got %d bytes", file.Name, len(file.Data), len(got))
+				}
+			}
+		})
+	}
+}
+
+func TestFileHashEntry(t *testing.T) {
+	const hashTableSize = 4
+	// Find two file names starting lookup at the same hash table entry, so that
+	// the second is stored in the next entry.
+	var first, second string
+	firstIndex := uint32(0)
+	for i := 0; len(second) == 0; i++ {
+		name := fmt.Sprintf(`data\global\excel\file%d.txt`, i)
+		index, _, _ := HashFileName(name)
+		switch {
+		case len(first) == 0:
+			first, firstIndex = name, index%hashTableSize
+		case index%hashTableSize == firstIndex:
+			second = name
+		}
+	}
+	const missing = `data\global\excel\missing.txt`
+	golden := []struct {
+		name string
+		// Modifies the hash table entries, given the index of the entry of the
+		// first file.
+		modify func(entries []HashTableEntry, index uint32)
+		// Expected files found and not found.
+		found, notFound []string
+	}{
+		{name: "probed", found: []string{first, second}, notFound: []string{missing}},
+		{
+			name: "deleted entry skipped",
+			modify: func(entries []HashTableEntry, index uint32) {
+				entries[index].BlockIndex = BlockIndexDeleted
+			},
+			found:    []string{second},
+			notFound: []string{first},
+		},
+		{
+			name: "free entry stops lookup",
+			modify: func(entries []HashTableEntry, index uint32) {
+				entries[index].BlockIndex = BlockIndexFree
+			},
+			notFound: []string{first, second},
+		},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{
+				Files: []mpqtest.File{
+					{Name: first, Data: books},
+					{Name: second, Data: books},
+				},
+				HashTableSize: hashTableSize,
+			}
+			archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if g.modify != nil {
+				g.modify(archive.HashTableEntries, firstIndex)
+			}
+			for _, name := range g.found {
+				if _, ok := archive.FileHashEntry(name); !ok {
+					t.Errorf("file %q not found", name)
+				}
+			}
+			for _, name := range g.notFound {
+				if _, ok := archive.FileHashEntry(name); ok {
+					t.Errorf("file %q unexpectedly found", name)
+				}
+			}
+		})
+	}
+}
+
+func TestHashEntryLocale(t *testing.T) {
+	const bookPath = `data\global\excel\books.txt`
+	golden := []struct {
+		name   string
+		locale uint16
+	}{
+		{name: "neutral", locale: 0},
+		{name: "english", locale: 0x0409},
+		{name: "german", locale: 0x0407},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: []mpqtest.File{{Name: bookPath, Data: books, Locale: g.locale}}}
+			archive, err := Load(a.Write(t, t.TempDir(), "d2data.mpq"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry, ok := archive.FileHashEntry(bookPath)
+			if !ok {
+				t.Fatalf("file %q not found", bookPath)
+			}
+			if entry.Locale != g.locale {
+				t.Errorf("locale mismatch; expected 0x%04X, got 0x%04X", g.locale, entry.Locale)
+			}
+			if entry.Platform != 0 {
+				t.Errorf("platform mismatch; expected 0, got 0x%04X", entry.Platform)
+			}
+		})
+	}
+}
+
+func TestLoadMalformedTables(t *testing.T) {
+	golden := []struct {
+		name string
+		// Offset within the MPQ header of the 32-bit value to set.
+		offset int
+		value  uint32
+	}{
+		{name: "hash table offset", offset: 16, value: 0x7FFFFFFF},
+		{name: "block table offset", offset: 20, value: 0x7FFFFFFF},
+		{name: "hash table entries", offset: 24, value: 0x10000000},
+		{name: "block table entries", offset: 28, value: 0xFFFFFFFF},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: []mpqtest.File{{Name: `data\global\excel\books.txt`, Data: books}}}
+			data := a.Bytes()
+			binary.LittleEndian.PutUint32(data[g.offset:], g.value)
+			mpqPath := filepath.Join(t.TempDir(), "d2data.mpq")
+			if err := ioutil.WriteFile(mpqPath, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(mpqPath)
+			if want := "extends past end of file"; err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("error mismatch; expected error containing %q, got %v", want, err)
+			}
+		})
+	}
+}
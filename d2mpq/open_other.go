@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package d2mpq
+
+import "os"
+
+// openShared opens the named MPQ file for reading. On Unix-like systems files
+// are not locked on open, so the MPQ file may be read while other processes
+// (e.g. the game) hold it open for writing.
+func openShared(fileName string) (*os.File, error) {
+	return os.Open(fileName)
+}
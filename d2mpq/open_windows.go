@@ -0,0 +1,26 @@
+package d2mpq
+
+import (
+	"os"
+	"syscall"
+)
+
+// openShared opens the named MPQ file for reading in shared mode, so that
+// other processes (e.g. the game) may keep the file open for reading and
+// writing, or rename or delete it, while it is being read.
+//
+// os.Open does not request FILE_SHARE_DELETE, which causes opening to fail
+// with a sharing violation when another process holds the file open with
+// delete access (as done by patchers replacing the file in place).
+func openShared(fileName string) (*os.File, error) {
+	pathp, err := syscall.UTF16PtrFromString(fileName)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: fileName, Err: err}
+	}
+	const shareMode = syscall.FILE_SHARE_READ | syscall.FILE_SHARE_WRITE | syscall.FILE_SHARE_DELETE
+	h, err := syscall.CreateFile(pathp, syscall.GENERIC_READ, shareMode, nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: fileName, Err: err}
+	}
+	return os.NewFile(uintptr(h), fileName), nil
+}
@@ -0,0 +1,160 @@
+package d2mpq
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Header sizes of MPQ format versions.
+const (
+	headerSizeV1 = 32
+	headerSizeV2 = 44
+)
+
+// hashEntrySize and blockEntrySize are the sizes in bytes of hash and block
+// table entries.
+const (
+	hashEntrySize  = 16
+	blockEntrySize = 16
+)
+
+// LoadProtected loads an MPQ file which may have been altered to thwart MPQ
+// tools ("protected" archives), and returns a MPQ structure. The following
+// un-protection heuristics are applied:
+//
+//  1. The declared header size is ignored, and the format version is trusted
+//     only if it is a known version; archives of unknown format version are
+//     read as format version 1.
+//  2. Hash and block table entry counts are clamped to the number of entries
+//     that fit between the table offset and the end of the file, as the
+//     declared archive size may be fake.
+//  3. Fake block table entries (those extending past the end of the file, or
+//     with a compressed size larger than the file) are cleared, and hash table
+//     entries referring to them or to non-existent blocks are removed.
+//
+// The heuristics applied are recorded in Repairs. If the heuristics do not
+// help, reading files of the archive fails as it would for any malformed
+// archive; the archive is not cached, so it may still be loaded using Load.
+// ErrCryptoUninitialized is returned if the crypto buffer has not yet been
+// initialized.
+func LoadProtected(fileName string) (*MPQ, error) {
+	if !cryptoBufferInitialized() {
+		return nil, ErrCryptoUninitialized
+	}
+	result := &MPQ{
+		FileName:  fileName,
+		fileCache: make(map[string][]byte),
+		fileList:  &fileListCache{},
+		attrs:     &attributesCache{},
+	}
+	file, err := openShared(fileName)
+	if err != nil {
+		return nil, err
+	}
+	result.File = file
+	if err := result.readProtectedHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return result, nil
+}
+
+// readProtectedHeader reads the header and tables of a protected MPQ file.
+func (v *MPQ) readProtectedHeader() error {
+	fi, err := v.File.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := fi.Size()
+	if err := v.seekHeader(); err != nil {
+		return err
+	}
+	if err := binary.Read(v.File, binary.LittleEndian, &v.Data); err != nil {
+		return err
+	}
+	if string(v.Data.Magic[:]) != headerMagic {
+		return errors.New("invalid mpq header")
+	}
+	// Heuristic 1: ignore declared header size and unknown format versions.
+	switch v.Data.FormatVersion {
+	case FormatVersion1:
+	case FormatVersion2:
+		if err := binary.Read(v.File, binary.LittleEndian, &v.DataV2); err != nil {
+			return err
+		}
+	default:
+		v.repair("unknown format version %d; reading as format version 1", v.Data.FormatVersion)
+		v.Data.FormatVersion = FormatVersion1
+	}
+	if v.Data.HeaderSize != headerSizeV1 && v.Data.HeaderSize != headerSizeV2 {
+		v.repair("ignored declared header size %d", v.Data.HeaderSize)
+	}
+	// Heuristic 2: clamp table entry counts to the file size.
+	v.Data.HashTableEntries = v.clampEntries("hash", v.hashTablePos(), v.Data.HashTableEntries, hashEntrySize, fileSize)
+	v.Data.BlockTableEntries = v.clampEntries("block", v.blockTablePos(), v.Data.BlockTableEntries, blockEntrySize, fileSize)
+	if err := v.loadHashTable(); err != nil {
+		return err
+	}
+	if err := v.loadBlockTable(); err != nil {
+		return err
+	}
+	if v.DataV2.HiBlockTableOffset != 0 {
+		end := v.HeaderOffset + int64(v.DataV2.HiBlockTableOffset) + 2*int64(v.Data.BlockTableEntries)
+		if end > fileSize {
+			v.repair("ignored hi-block table extending past end of file")
+		} else if err := v.loadHiBlockTable(); err != nil {
+			return err
+		}
+	}
+	// Heuristic 3: clear fake block table entries.
+	fake := 0
+	for i, block := range v.BlockTableEntries {
+		if block.Flags == 0 {
+			continue
+		}
+		end := block.Position() + int64(block.CompressedFileSize)
+		if block.Position() < 0 || end > fileSize || int64(block.CompressedFileSize) > fileSize {
+			v.BlockTableEntries[i] = BlockTableEntry{}
+			fake++
+		}
+	}
+	if fake > 0 {
+		v.repair("cleared %d fake block table entries", fake)
+	}
+	removed := 0
+	for i, entry := range v.HashTableEntries {
+		if entry.BlockIndex >= 0xFFFFFFFE {
+			continue
+		}
+		if entry.BlockIndex >= uint32(len(v.BlockTableEntries)) || v.BlockTableEntries[entry.BlockIndex].Flags == 0 {
+			// Mark entry as deleted, with name hashes that match no file.
+			v.HashTableEntries[i] = HashTableEntry{NamePartA: 0xFFFFFFFF, NamePartB: 0xFFFFFFFF, Locale: 0xFFFF, Platform: 0xFFFF, BlockIndex: BlockIndexDeleted}
+			removed++
+		}
+	}
+	if removed > 0 {
+		v.repair("removed %d hash table entries referring to invalid blocks", removed)
+	}
+	return nil
+}
+
+// clampEntries returns the number of table entries of the given size that fit
+// between the table offset and the end of the file.
+func (v *MPQ) clampEntries(table string, offset int64, n, entrySize uint32, fileSize int64) uint32 {
+	if offset < 0 || offset > fileSize {
+		v.repair("%s table offset 0x%X past end of file; ignoring %s table", table, offset, table)
+		return 0
+	}
+	max := uint32((fileSize - offset) / int64(entrySize))
+	if n > max {
+		v.repair("clamped %s table from %d to %d entries", table, n, max)
+		return max
+	}
+	return n
+}
+
+// repair records an applied un-protection heuristic.
+func (v *MPQ) repair(format string, args ...interface{}) {
+	v.Repairs = append(v.Repairs, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,24 @@
+package d2mpq
+
+import (
+	"github.com/OpenDiablo2/MpqViewer/internal/lru"
+)
+
+// sectorKey identifies a sector of a file within an MPQ archive.
+type sectorKey struct {
+	// Index of the block table entry of the file.
+	block uint32
+	// Index of the sector within the block.
+	sector uint32
+}
+
+// SetSectorCacheSize enables an LRU cache of the decompressed sectors of files
+// read from the MPQ archive, holding at most maxSize bytes; or disables the
+// cache if maxSize is 0. Any previously cached sectors are discarded.
+func (v *MPQ) SetSectorCacheSize(maxSize int64) {
+	if maxSize <= 0 {
+		v.sectors = nil
+		return
+	}
+	v.sectors = lru.New[sectorKey](maxSize)
+}
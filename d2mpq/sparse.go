@@ -0,0 +1,47 @@
+package d2mpq
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// sparseDecompress decompresses the given sparse-compressed data (compression
+// mask 0x20), which run-length encodes runs of zero bytes.
+//
+// The data starts with the big-endian 32-bit size of the decompressed data,
+// followed by chunks each starting with a control byte. If the high bit of the
+// control byte is set, the next (control&0x7F)+1 bytes are copied verbatim;
+// otherwise, (control&0x7F)+3 zero bytes are output. Bytes not covered by any
+// chunk are zero. The decompressed size may not exceed maxSize.
+func sparseDecompress(data []byte, maxSize uint32) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("sparse data too short; expected at least 4 bytes, got %d", len(data))
+	}
+	size := binary.BigEndian.Uint32(data)
+	if size > maxSize {
+		return nil, fmt.Errorf("sparse size %d exceeds expected size of %d bytes", size, maxSize)
+	}
+	out := make([]byte, size)
+	data = data[4:]
+	pos := 0
+	for len(data) > 0 && pos < len(out) {
+		control := data[0]
+		data = data[1:]
+		if control&0x80 != 0 {
+			n := int(control&0x7F) + 1
+			if n > len(data) {
+				return nil, fmt.Errorf("sparse chunk of %d bytes exceeds remaining input of %d bytes", n, len(data))
+			}
+			pos += copy(out[pos:], data[:n])
+			data = data[n:]
+			continue
+		}
+		n := int(control&0x7F) + 3
+		if pos+n > len(out) {
+			n = len(out) - pos
+		}
+		// out is zero-initialized.
+		pos += n
+	}
+	return out, nil
+}
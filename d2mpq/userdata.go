@@ -0,0 +1,61 @@
+package d2mpq
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Magic identifiers of MPQ headers.
+const (
+	headerMagic   = "MPQ\x1A"
+	userDataMagic = "MPQ\x1B"
+)
+
+// headerAlignment is the alignment of MPQ headers within files; an MPQ header
+// preceded by other data (e.g. a data fork or executable stub) is searched for
+// at multiples of the alignment.
+const headerAlignment = 0x200
+
+// UserData represents the user-data header which may precede the MPQ header,
+// and which records the offset of the MPQ header relative to the user-data
+// header.
+type UserData struct {
+	Magic [4]byte
+	// Maximum size of the user data.
+	UserDataSize uint32
+	// Offset of the MPQ header, relative to the start of the user-data header.
+	HeaderOffset uint32
+	// Size of the user-data header.
+	UserDataHeaderSize uint32
+}
+
+// seekHeader locates the MPQ header within the file and seeks to it. The MPQ
+// header is searched for at every multiple of headerAlignment; when a
+// user-data header is found first, the MPQ header is located using its stored
+// header offset. The offset of the MPQ header is recorded in HeaderOffset.
+func (v *MPQ) seekHeader() error {
+	var magic [4]byte
+	for offset := int64(0); ; offset += headerAlignment {
+		if _, err := v.File.ReadAt(magic[:], offset); err != nil {
+			if err == io.EOF {
+				return errors.New("invalid mpq header")
+			}
+			return err
+		}
+		switch string(magic[:]) {
+		case headerMagic:
+			v.HeaderOffset = offset
+		case userDataMagic:
+			r := io.NewSectionReader(v.File, offset, 16)
+			if err := binary.Read(r, binary.LittleEndian, &v.UserData); err != nil {
+				return err
+			}
+			v.HeaderOffset = offset + int64(v.UserData.HeaderOffset)
+		default:
+			continue
+		}
+		_, err := v.File.Seek(v.HeaderOffset, io.SeekStart)
+		return err
+	}
+}
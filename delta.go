@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// listfileDelta returns the file paths of the given external listfile which are
+// present in an MPQ archive but missing from the embedded (listfile) of that
+// MPQ archive; i.e. the files revealed by the external listfile beyond the
+// embedded one. MPQ archives without an embedded (listfile) are treated as
+// having an empty one.
+func listfileDelta(archives []*d2mpq.MPQ, external []string) ([]string, error) {
+	var extras []string
+	seen := make(map[string]bool)
+	for _, archive := range archives {
+		embedded := make(map[string]bool)
+		if archive.FileExists("(listfile)") {
+			files, err := archive.GetFileList()
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			for _, filePath := range files {
+				if filePath = denormalize(filePath); len(filePath) > 0 {
+					embedded[archivePath(filePath)] = true
+				}
+			}
+		}
+		for _, filePath := range external {
+			key := archivePath(filePath)
+			if seen[key] || embedded[key] || !archive.FileExists(key) {
+				continue
+			}
+			seen[key] = true
+			extras = append(extras, filePath)
+		}
+	}
+	return extras, nil
+}
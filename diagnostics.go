@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Codes of diagnostics.
+const (
+	// File not found in any of the MPQ archives.
+	diagNotFound = "not-found"
+	// File contents could not be read; the file is skipped.
+	diagReadError = "read-error"
+	// Localized file failed to read; language-neutral file read instead.
+	diagLocaleFallback = "locale-fallback"
+	// Empty file path skipped.
+	diagEmptyPath = "empty-path"
+	// Size of file contents differs from block table.
+	diagSizeMismatch = "size-mismatch"
+	// File failed to extract with -continue-on-error; the file is skipped.
+	diagExtractError = "extract-error"
+)
+
+// diagnostic is a warning emitted during extraction, as written to the
+// diagnostics file of -diagnostics.
+type diagnostic struct {
+	// Code identifying the kind of warning (e.g. "not-found").
+	Code string `json:"code"`
+	// Severity of the warning; currently always "warning".
+	Severity string `json:"severity"`
+	// Normalized path of the file the warning refers to.
+	Path string `json:"path"`
+	// Human-readable message of the warning.
+	Message string `json:"message"`
+}
+
+// diagnostics collects the warnings emitted during extraction. It is safe for
+// concurrent use.
+type diagnostics struct {
+	mu    sync.Mutex
+	diags []diagnostic
+}
+
+// warn logs the warning of the given code and file path, and records it as a
+// diagnostic. A nil collector only logs the warning.
+func (d *diagnostics) warn(code, filePath, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Println(msg)
+	if d == nil {
+		return
+	}
+	diag := diagnostic{
+		Code:     code,
+		Severity: "warning",
+		Path:     normalize(filePath),
+		Message:  msg,
+	}
+	d.mu.Lock()
+	d.diags = append(d.diags, diag)
+	d.mu.Unlock()
+}
+
+// write writes the recorded diagnostics as a JSON array to the given path.
+func (d *diagnostics) write(diagPath string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	diags := d.diags
+	if diags == nil {
+		diags = []diagnostic{}
+	}
+	buf, err := json.MarshalIndent(diags, "", "\t")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(diagPath, append(buf, '\n'), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Printf("wrote %d diagnostic(s) to %q\n", len(diags), diagPath)
+	return nil
+}
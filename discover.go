@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// findArchives returns the paths of all MPQ archives (files with a ".mpq"
+// extension, in any case) below the given directory, in sorted order so that
+// the precedence of the MPQ archives is reproducible.
+func findArchives(dir string) ([]string, error) {
+	var mpqPaths []string
+	// Note, filepath.WalkDir requires Go 1.16.
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !info.IsDir() && strings.EqualFold(filepath.Ext(path), ".mpq") {
+			mpqPaths = append(mpqPaths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Strings(mpqPaths)
+	return mpqPaths, nil
+}
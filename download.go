@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// journal records the progress of an interrupted download, so that it may be
+// resumed from where it left off.
+type journal struct {
+	// URL of the remote MPQ archive.
+	URL string `json:"url"`
+	// Total size in bytes of the remote MPQ archive; or -1 if unknown.
+	Size int64 `json:"size"`
+	// Entity tag of the remote MPQ archive, used to detect changes between
+	// resumed downloads.
+	ETag string `json:"etag,omitempty"`
+}
+
+// downloadArchive downloads the remote MPQ archive at the given URL into dir,
+// and returns the path to the local copy of the MPQ archive along with the
+// number of bytes downloaded. The entire MPQ archive is downloaded before
+// any of its files are read; files are not read directly from the remote.
+//
+// The download is written to a ".part" file alongside a ".journal" file
+// recording its progress. When resume is set, an interrupted download is
+// resumed from the journal rather than started over. Failed range requests are
+// retried up to retries times.
+func downloadArchive(rawURL, dir string, resume bool, retries int) (string, int64, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", 0, errors.WithStack(err)
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return "", 0, errors.Errorf("unable to determine MPQ archive name from URL %q", rawURL)
+	}
+	dstPath := filepath.Join(dir, name)
+	partPath := dstPath + ".part"
+	journalPath := dstPath + ".journal"
+
+	// Resume interrupted download.
+	var j journal
+	var offset int64
+	if resume {
+		if err := readJournal(journalPath, &j); err == nil && j.URL == rawURL {
+			if fi, err := os.Stat(partPath); err == nil {
+				offset = fi.Size()
+			}
+			infof("resuming download of %q at byte %d\n", rawURL, offset)
+		} else {
+			j = journal{}
+		}
+	}
+	if offset == 0 {
+		j = journal{URL: rawURL, Size: -1}
+	}
+
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", 0, errors.WithStack(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return "", 0, errors.WithStack(err)
+	}
+
+	// Download remaining contents, retrying failed range requests.
+	var downloaded int64
+	for attempt := 0; ; attempt++ {
+		end, n, err := downloadRange(f, &j, offset)
+		downloaded += n
+		offset = end
+		if werr := writeJournal(journalPath, &j); werr != nil {
+			return "", downloaded, errors.WithStack(werr)
+		}
+		if err == nil {
+			break
+		}
+		if attempt >= retries {
+			return "", downloaded, errors.Wrapf(err, "download of %q failed after %d retries", rawURL, retries)
+		}
+		log.Printf("download of %q interrupted at byte %d; retrying (%d/%d); %v\n", rawURL, offset, attempt+1, retries, err)
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+	if err := f.Close(); err != nil {
+		return "", downloaded, errors.WithStack(err)
+	}
+	if err := os.Rename(partPath, dstPath); err != nil {
+		return "", downloaded, errors.WithStack(err)
+	}
+	if err := os.Remove(journalPath); err != nil && !os.IsNotExist(err) {
+		return "", downloaded, errors.WithStack(err)
+	}
+	return dstPath, downloaded, nil
+}
+
+// downloadRange downloads the contents of the remote MPQ archive starting at
+// the given offset and writes them to f. The journal is updated with the size
+// and entity tag reported by the server. downloadRange returns the offset up to
+// which f holds valid contents and the number of bytes downloaded.
+func downloadRange(f *os.File, j *journal, offset int64) (int64, int64, error) {
+	if j.Size >= 0 && offset >= j.Size {
+		return offset, 0, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, j.URL, nil)
+	if err != nil {
+		return offset, 0, errors.WithStack(err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if len(j.ETag) > 0 {
+			req.Header.Set("If-Range", j.ETag)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return offset, 0, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Server ignored range request (or remote archive changed); start over.
+		if offset > 0 {
+			log.Printf("server returned full contents of %q; restarting download\n", j.URL)
+			if err := f.Truncate(0); err != nil {
+				return offset, 0, errors.WithStack(err)
+			}
+			offset = 0
+		}
+		j.Size = resp.ContentLength
+	case http.StatusPartialContent:
+		// A server (or proxy) returning a range other than the one requested
+		// would corrupt the download; start over.
+		start, size, ok := partialContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			log.Printf("server returned range %q of %q instead of range at byte %d; restarting download\n", resp.Header.Get("Content-Range"), j.URL, offset)
+			resp.Body.Close()
+			if err := f.Truncate(0); err != nil {
+				return offset, 0, errors.WithStack(err)
+			}
+			*j = journal{URL: j.URL, Size: -1}
+			return downloadRange(f, j, 0)
+		}
+		if size >= 0 {
+			j.Size = size
+		} else if j.Size < 0 && resp.ContentLength >= 0 {
+			j.Size = offset + resp.ContentLength
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The offset is at or beyond the end of the remote archive. The download
+		// is complete only if the offset is exactly the size of the remote
+		// archive; otherwise, the remote archive changed (e.g. shrunk) since the
+		// interrupted download, and the download is started over.
+		size, ok := unsatisfiedRangeSize(resp.Header.Get("Content-Range"))
+		if !ok {
+			size = j.Size
+		}
+		if size >= 0 && offset == size {
+			j.Size = size
+			return offset, 0, nil
+		}
+		log.Printf("requested range of %q at byte %d not satisfiable (remote size %d); restarting download\n", j.URL, offset, size)
+		if err := f.Truncate(0); err != nil {
+			return offset, 0, errors.WithStack(err)
+		}
+		*j = journal{URL: j.URL, Size: -1}
+		return downloadRange(f, j, 0)
+	default:
+		return offset, 0, errors.Errorf("unexpected HTTP status %q", resp.Status)
+	}
+	if etag := resp.Header.Get("ETag"); len(etag) > 0 {
+		j.ETag = etag
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, 0, errors.WithStack(err)
+	}
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return offset + n, n, errors.WithStack(err)
+	}
+	if j.Size >= 0 && offset+n < j.Size {
+		return offset + n, n, errors.Errorf("short read; got %d of %d bytes", offset+n, j.Size)
+	}
+	return offset + n, n, nil
+}
+
+// unsatisfiedRangeSize returns the size of the remote archive reported by the
+// Content-Range header of a 416 (Range Not Satisfiable) response, of the form
+// "bytes */size", and a boolean indicating whether the size was reported.
+func unsatisfiedRangeSize(contentRange string) (int64, bool) {
+	const prefix = "bytes */"
+	if !strings.HasPrefix(contentRange, prefix) {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(contentRange[len(prefix):], 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// readJournal reads the download journal at the given path.
+func readJournal(journalPath string, j *journal) error {
+	buf, err := ioutil.ReadFile(journalPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := json.Unmarshal(buf, j); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeJournal writes the download journal to the given path.
+func writeJournal(journalPath string, j *journal) error {
+	buf, err := json.Marshal(j)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(journalPath, buf, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// partialContentRange returns the first byte position and the size of the
+// remote archive reported by the Content-Range header of a 206 (Partial
+// Content) response, of the form "bytes start-end/size", and a boolean
+// indicating whether the header is well-formed. The size is -1 if unknown
+// ("bytes start-end/*").
+func partialContentRange(contentRange string) (int64, int64, bool) {
+	const prefix = "bytes "
+	if !strings.HasPrefix(contentRange, prefix) {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(contentRange[len(prefix):], "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	if parts[1] == "*" {
+		return start, -1, true
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size <= end {
+		return 0, 0, false
+	}
+	return start, size, true
+}
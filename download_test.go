@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadArchiveResume(t *testing.T) {
+	content := bytes.Repeat([]byte("d2data"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "d2data.mpq", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+	rawURL := srv.URL + "/d2data.mpq"
+
+	golden := []struct {
+		name string
+		// Contents of the partial download; nil if none.
+		part []byte
+		// Number of bytes expected to be downloaded.
+		want int64
+	}{
+		{name: "fresh", part: nil, want: int64(len(content))},
+		{name: "interrupted", part: content[:1000], want: int64(len(content) - 1000)},
+		// Range starting at end of remote archive is not satisfiable (416), but
+		// the download is complete.
+		{name: "complete", part: content, want: 0},
+		// Range starting beyond end of remote archive is not satisfiable (416),
+		// and the download is started over.
+		{name: "remote shrunk", part: append(append([]byte(nil), content...), "stale"...), want: int64(len(content))},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dstPath := filepath.Join(dir, "d2data.mpq")
+			if g.part != nil {
+				if err := ioutil.WriteFile(dstPath+".part", g.part, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := writeJournal(dstPath+".journal", &journal{URL: rawURL, Size: -1}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			gotPath, n, err := downloadArchive(rawURL, dir, true, 0)
+			if err != nil {
+				t.Fatalf("unexpected error; %+v", err)
+			}
+			if gotPath != dstPath {
+				t.Errorf("path mismatch; expected %q, got %q", dstPath, gotPath)
+			}
+			if n != g.want {
+				t.Errorf("downloaded bytes mismatch; expected %d, got %d", g.want, n)
+			}
+			got, err := ioutil.ReadFile(dstPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("contents mismatch; expected %d bytes, got %d bytes", len(content), len(got))
+			}
+		})
+	}
+}
+
+func TestDownloadArchiveQuiet(t *testing.T) {
+	content := bytes.Repeat([]byte("d2data"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "d2data.mpq", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+	rawURL := srv.URL + "/d2data.mpq"
+	defer func(q bool, out io.Writer) { quiet, infoOut = q, out }(quiet, infoOut)
+
+	golden := []struct {
+		quiet bool
+		// Expect "resuming download" in informational output.
+		want bool
+	}{
+		{quiet: false, want: true},
+		{quiet: true, want: false},
+	}
+	for _, g := range golden {
+		t.Run(fmt.Sprintf("quiet=%v", g.quiet), func(t *testing.T) {
+			dir := t.TempDir()
+			dstPath := filepath.Join(dir, "d2data.mpq")
+			if err := ioutil.WriteFile(dstPath+".part", content[:1000], 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeJournal(dstPath+".journal", &journal{URL: rawURL, Size: -1}); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			quiet, infoOut = g.quiet, &out
+			if _, _, err := downloadArchive(rawURL, dir, true, 0); err != nil {
+				t.Fatalf("unexpected error; %+v", err)
+			}
+			if got := strings.Contains(out.String(), "resuming download"); got != g.want {
+				t.Errorf("resuming download output mismatch; expected %v, got %q", g.want, out.String())
+			}
+			if g.quiet && out.Len() > 0 {
+				t.Errorf("unexpected informational output with -quiet; got %q", out.String())
+			}
+		})
+	}
+}
+
+func TestDownloadArchiveMisalignedRange(t *testing.T) {
+	content := bytes.Repeat([]byte("d2data"), 1000)
+	golden := []struct {
+		name string
+		// Start of the range returned by the server for range requests.
+		start int
+		// Content-Range header returned for range requests; empty to derive it
+		// from start.
+		contentRange string
+	}{
+		{name: "range before offset", start: 500},
+		{name: "range past offset", start: 1500},
+		{name: "malformed content range", start: 1000, contentRange: "bytes 1000"},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(r.Header.Get("Range")) == 0 {
+					w.Write(content)
+					return
+				}
+				contentRange := g.contentRange
+				if len(contentRange) == 0 {
+					contentRange = fmt.Sprintf("bytes %d-%d/%d", g.start, len(content)-1, len(content))
+				}
+				w.Header().Set("Content-Range", contentRange)
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[g.start:])
+			}))
+			defer srv.Close()
+			rawURL := srv.URL + "/d2data.mpq"
+			dir := t.TempDir()
+			dstPath := filepath.Join(dir, "d2data.mpq")
+			if err := ioutil.WriteFile(dstPath+".part", content[:1000], 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeJournal(dstPath+".journal", &journal{URL: rawURL, Size: -1}); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := downloadArchive(rawURL, dir, true, 0); err != nil {
+				t.Fatalf("unexpected error; %+v", err)
+			}
+			got, err := ioutil.ReadFile(dstPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("contents mismatch; expected %d bytes, got %d bytes", len(content), len(got))
+			}
+		})
+	}
+}
+
+func TestPartialContentRange(t *testing.T) {
+	golden := []struct {
+		in    string
+		start int64
+		size  int64
+		ok    bool
+	}{
+		{in: "bytes 1000-5999/6000", start: 1000, size: 6000, ok: true},
+		{in: "bytes 0-0/1", start: 0, size: 1, ok: true},
+		{in: "bytes 1000-5999/*", start: 1000, size: -1, ok: true},
+		{in: "", ok: false},
+		{in: "bytes */6000", ok: false},
+		{in: "bytes 1000/6000", ok: false},
+		{in: "bytes 5999-1000/6000", ok: false},
+		{in: "bytes 1000-5999/5999", ok: false},
+	}
+	for _, g := range golden {
+		start, size, ok := partialContentRange(g.in)
+		if start != g.start || size != g.size || ok != g.ok {
+			t.Errorf("%q: expected (%d, %d, %v), got (%d, %d, %v)", g.in, g.start, g.size, g.ok, start, size, ok)
+		}
+	}
+}
+
+func TestUnsatisfiedRangeSize(t *testing.T) {
+	golden := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{in: "bytes */6000", want: 6000, ok: true},
+		{in: "bytes */0", want: 0, ok: true},
+		{in: "", ok: false},
+		{in: "bytes 0-99/6000", ok: false},
+		{in: "bytes */*", ok: false},
+	}
+	for _, g := range golden {
+		got, ok := unsatisfiedRangeSize(g.in)
+		if got != g.want || ok != g.ok {
+			t.Errorf("%q: expected (%d, %v), got (%d, %v)", g.in, g.want, g.ok, got, ok)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// excludePatterns is a list of glob patterns of files to skip.
+type excludePatterns []string
+
+// parseExcludePatterns parses the given comma-separated list of glob patterns
+// (see path.Match). Patterns are matched case-insensitively against normalized
+// (slash-separated) file paths without leading slash; patterns without a slash
+// are matched against the base name of file paths.
+func parseExcludePatterns(s string) (excludePatterns, error) {
+	var patterns excludePatterns
+	for _, pattern := range strings.Split(s, ",") {
+		pattern = strings.TrimPrefix(strings.ToLower(normalize(strings.TrimSpace(pattern))), "/")
+		if len(pattern) == 0 {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid exclude pattern %q", pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// match reports whether the given file path matches any of the patterns.
+func (patterns excludePatterns) match(filePath string) bool {
+	name := strings.TrimPrefix(strings.ToLower(normalize(filePath)), "/")
+	for _, pattern := range patterns {
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the file paths not matching any of the patterns.
+func (patterns excludePatterns) filter(filePaths []string) []string {
+	var kept []string
+	for _, filePath := range filePaths {
+		if patterns.match(filePath) {
+			infof("skipping %q (excluded)\n", normalize(filePath))
+			continue
+		}
+		kept = append(kept, filePath)
+	}
+	return kept
+}
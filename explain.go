@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// explain prints a trace of how the given file path resolves to an MPQ archive;
+// i.e. each MPQ archive checked (in search order), the hash and block table
+// entries matching the file path, and the MPQ archive chosen by readFile along
+// with the reason. No files are extracted.
+func explain(archives []*d2mpq.MPQ, filePath string, priorities map[*d2mpq.MPQ]int, opts options) error {
+	if len(filePath) == 0 {
+		return errors.New("empty file path")
+	}
+	key := archivePath(denormalize(filePath))
+	fmt.Printf("resolving %q as %q\n", filePath, key)
+	_, hashA, hashB := d2mpq.HashFileName(key)
+	fmt.Printf("hash A 0x%08X, hash B 0x%08X\n", hashA, hashB)
+	for i, group := range archiveGroups(archives, opts) {
+		kind := "unlabelled MPQ archives"
+		if len(group) == 1 {
+			if label, ok := opts.labels[group[0]]; ok {
+				kind = fmt.Sprintf("labelled MPQ archive %q", label)
+			}
+		}
+		fmt.Printf("group %d (%s):\n", i+1, kind)
+		var chosen *d2mpq.MPQ
+		for j, archive := range group {
+			exists := archive.FileExists(key)
+			fmt.Printf("  %d. %q (priority %d): FileExists %v\n", j+1, archive.FileName, priorities[archive], exists)
+			for index, entry := range archive.HashTableEntries {
+				if entry.NamePartA != hashA || entry.NamePartB != hashB || entry.BlockIndex == d2mpq.BlockIndexFree {
+					continue
+				}
+				fmt.Printf("     hash entry %d: locale 0x%04X, platform 0x%04X, block %d", index, entry.Locale, entry.Platform, entry.BlockIndex)
+				switch {
+				case entry.BlockIndex == d2mpq.BlockIndexDeleted:
+					fmt.Print(" (deleted)\n")
+					continue
+				case entry.BlockIndex >= uint32(len(archive.BlockTableEntries)):
+					fmt.Print(" (invalid block index)\n")
+					continue
+				}
+				block := archive.BlockTableEntries[entry.BlockIndex]
+				fmt.Printf(", offset 0x%08X, compressed %d, uncompressed %d, flags 0x%08X (%s)\n", block.Position(), block.CompressedFileSize, block.UncompressedFileSize, uint32(block.Flags), compressionMethod(archive, block))
+			}
+			if exists && chosen == nil {
+				chosen = archive
+				if entry, ok := archive.FileHashEntry(key); ok {
+					fmt.Printf("     resolves to locale 0x%04X", entry.Locale)
+					if _, ok := neutralArchive(archive, key); ok {
+						if opts.localeFallback {
+							fmt.Print("; falls back to language-neutral entry on read error")
+						} else {
+							fmt.Print("; language-neutral entry also present (see -locale-fallback)")
+						}
+					}
+					fmt.Println()
+				}
+			}
+		}
+		if chosen == nil {
+			fmt.Println("  chosen: none; file not found in any MPQ archive of group")
+			continue
+		}
+		fmt.Printf("  chosen: %q; first MPQ archive containing the file in search order (priority %d", chosen.FileName, priorities[chosen])
+		for _, archive := range group {
+			if archive != chosen && archive.FileExists(key) {
+				fmt.Printf(", overriding %q of priority %d", archive.FileName, priorities[archive])
+			}
+		}
+		fmt.Println(")")
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// fileTypes lists the file types recognized by detectType, excluding the
+// fallback type "bin".
+var fileTypes = []string{"bik", "bmp", "dc6", "dcc", "dt1", "jpg", "mpq", "pcx", "png", "smk", "text", "wav"}
+
+// detectType returns the file type of the given file contents, as determined by
+// its magic number or header; or "bin" if the file type is unknown.
+func detectType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("BIK")):
+		return "bik"
+	case bytes.HasPrefix(data, []byte("SMK2")), bytes.HasPrefix(data, []byte("SMK4")):
+		return "smk"
+	case len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		return "wav"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("\xFF\xD8\xFF")):
+		return "jpg"
+	case bytes.HasPrefix(data, []byte("BM")) && len(data) >= 14 && binary.LittleEndian.Uint32(data[2:6]) == uint32(len(data)):
+		return "bmp"
+	case bytes.HasPrefix(data, []byte("MPQ\x1A")):
+		return "mpq"
+	case len(data) >= 24 && binary.LittleEndian.Uint32(data[0:4]) == 6 && binary.LittleEndian.Uint32(data[4:8]) == 1 && binary.LittleEndian.Uint32(data[8:12]) == 0:
+		// DC6 version 6, flags 1, encoding 0.
+		return "dc6"
+	case len(data) >= 8 && binary.LittleEndian.Uint32(data[0:4]) == 7 && binary.LittleEndian.Uint32(data[4:8]) == 6:
+		// DT1 version 7.6.
+		return "dt1"
+	case len(data) >= 2 && data[0] == 0x74 && data[1] == 0x06:
+		// DCC signature 0x74, version 6.
+		return "dcc"
+	case len(data) >= 128 && data[0] == 0x0A && data[1] <= 5 && data[2] == 1:
+		// PCX manufacturer 0x0A, RLE encoding.
+		return "pcx"
+	case isText(data):
+		return "text"
+	}
+	return "bin"
+}
+
+// isText reports whether the given file contents is valid UTF-8 text without
+// control characters other than whitespace.
+func isText(data []byte) bool {
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 || !utf8.Valid(data) {
+		return false
+	}
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// isFileType reports whether the given name is a file type recognized by
+// detectType.
+func isFileType(name string) bool {
+	if name == "bin" {
+		return true
+	}
+	for _, fileType := range fileTypes {
+		if name == fileType {
+			return true
+		}
+	}
+	return false
+}
+
+// typeCounts records the number of extracted files of each file type.
+type typeCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// add records an extracted file of the given file type.
+func (t *typeCounts) add(fileType string) {
+	t.mu.Lock()
+	if t.counts == nil {
+		t.counts = make(map[string]int)
+	}
+	t.counts[fileType]++
+	t.mu.Unlock()
+}
+
+// print prints the number of extracted files of each file type.
+func (t *typeCounts) print() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var names []string
+	for name := range t.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("extracted %d file(s) of type %q\n", t.counts[name], name)
+	}
+}
+
+// fileTypesHelp returns the comma-separated list of recognized file types.
+func fileTypesHelp() string {
+	return strings.Join(append(append([]string(nil), fileTypes...), "bin"), ", ")
+}
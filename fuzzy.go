@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// fuzzyMatch is a file path ranked by similarity to a loose asset name.
+type fuzzyMatch struct {
+	// File path.
+	filePath string
+	// Similarity score in [0, 1], where 1 is an exact match.
+	score float64
+}
+
+// fuzzyScore returns the similarity in [0, 1] of the given file path to the
+// loose asset name, comparing case-insensitively against the base name of the
+// file path with and without its extension as well as the full path.
+func fuzzyScore(name, filePath string) float64 {
+	name = strings.ToLower(normalize(name))
+	full := strings.ToLower(normalize(filePath))
+	base := path.Base(full)
+	stem := strings.TrimSuffix(base, path.Ext(base))
+	switch {
+	case name == full, name == base, name == stem:
+		return 1
+	case strings.HasSuffix(full, "/"+name):
+		return 0.95
+	case strings.HasPrefix(stem, name):
+		return 0.9 * float64(len(name)) / float64(len(stem))
+	case strings.Contains(base, name):
+		return 0.8 * float64(len(name)) / float64(len(base))
+	case strings.Contains(full, name):
+		// Scale by the full path, as the name may match directories of the
+		// path (e.g. "global/excel").
+		return 0.8 * float64(len(name)) / float64(len(full))
+	}
+	// Fall back to edit distance of stem.
+	dist := levenshtein(name, stem)
+	n := len(name)
+	if len(stem) > n {
+		n = len(stem)
+	}
+	return 0.8 * (1 - float64(dist)/float64(n))
+}
+
+// fuzzyRank returns the file paths ranked by similarity to the loose asset name,
+// in descending order of score. File paths scoring below threshold are omitted.
+func fuzzyRank(name string, filePaths []string, threshold float64) []fuzzyMatch {
+	var matches []fuzzyMatch
+	for _, filePath := range filePaths {
+		score := fuzzyScore(name, filePath)
+		if score < threshold {
+			continue
+		}
+		matches = append(matches, fuzzyMatch{filePath: filePath, score: score})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	return matches
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if del := prev[j] + 1; del < cur[j] {
+				cur[j] = del
+			}
+			if ins := cur[j-1] + 1; ins < cur[j] {
+				cur[j] = ins
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyRank(t *testing.T) {
+	golden := []struct {
+		name      string
+		asset     string
+		filePaths []string
+		// Expected file paths, in order of rank.
+		want []string
+	}{
+		{
+			name:      "exact stem",
+			asset:     "armor",
+			filePaths: []string{`data\global\excel\armorx.txt`, `data\global\excel\armor.txt`, `data\global\items\invarmor.dc6`},
+			want:      []string{`data\global\excel\armor.txt`, `data\global\excel\armorx.txt`, `data\global\items\invarmor.dc6`},
+		},
+		{
+			name:      "directory fragment",
+			asset:     "global/excel",
+			filePaths: []string{`data\global\excel\weapons.txt`, `data\global\excel\armor.txt`, `data\global\excel.txt`},
+			want:      []string{`data\global\excel.txt`, `data\global\excel\armor.txt`, `data\global\excel\weapons.txt`},
+		},
+		{
+			name:      "directory fragment below exact stem",
+			asset:     "excel",
+			filePaths: []string{`data\global\excel\ar.txt`, `data\local\excel.dc6`},
+			want:      []string{`data\local\excel.dc6`, `data\global\excel\ar.txt`},
+		},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			var got []string
+			for _, m := range fuzzyRank(g.asset, g.filePaths, 0) {
+				if m.score < 0 || m.score > 1 {
+					t.Errorf("%q: score %v out of range [0, 1]", m.filePath, m.score)
+				}
+				got = append(got, m.filePath)
+			}
+			if !reflect.DeepEqual(got, g.want) {
+				t.Errorf("rank mismatch; expected %q, got %q", g.want, got)
+			}
+		})
+	}
+}
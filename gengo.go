@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"strconv"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// writeGoSource writes a Go source file to the given path, declaring a map from
+// the normalized path of each file to its contents within the given package.
+// Files not present in any of the MPQ archives are skipped.
+//
+// The total uncompressed size of the files is checked against limit (in bytes)
+// before anything is written; a limit of 0 disables the check.
+func writeGoSource(archives []*d2mpq.MPQ, filePaths []string, goPath, pkgName string, limit int64, opts options) error {
+	if !token.IsIdentifier(pkgName) {
+		return errors.Errorf("invalid Go package name %q", pkgName)
+	}
+	var total int64
+	for _, filePath := range filePaths {
+		if size, ok := lookupFileSize(archives, filePath); ok {
+			total += int64(size)
+		}
+	}
+	if limit > 0 && total > limit {
+		return errors.Errorf("total size of files (%d bytes) exceeds Go source limit (%d bytes); see -gen-go-limit", total, limit)
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("// Code generated by MpqViewer; DO NOT EDIT.\n\n")
+	fmt.Fprintf(buf, "package %s\n\n", pkgName)
+	buf.WriteString("// Files maps from normalized file path to the contents of files extracted\n")
+	buf.WriteString("// from MPQ archives.\n")
+	buf.WriteString("var Files = map[string][]byte{\n")
+	seen := make(map[string]bool)
+	for _, filePath := range filePaths {
+		data, _, err := readFile(archives, filePath, opts)
+		if err != nil {
+			if errors.Cause(err) == ErrNotFound {
+				log.Printf("file not found %q\n", filePath)
+				continue
+			}
+			return errors.WithStack(err)
+		}
+		dstPath := recordedPath(filePath, opts)
+		if seen[dstPath] {
+			continue
+		}
+		seen[dstPath] = true
+		fmt.Printf("embedding: %q\n", dstPath)
+		fmt.Fprintf(buf, "\t%s: []byte(%s),\n", strconv.Quote(dstPath), strconv.Quote(string(data)))
+	}
+	buf.WriteString("}\n")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(goPath, src, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
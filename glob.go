@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"path"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// isGlob reports whether the given file path is a glob pattern; i.e. contains
+// any of the '*', '?' or '[' meta characters of path.Match.
+func isGlob(filePath string) bool {
+	return strings.ContainsAny(filePath, "*?[")
+}
+
+// expandGlobs returns the given file paths with each glob pattern replaced by
+// the files of the embedded (listfile) of each MPQ archive matching the
+// pattern. Patterns and file paths are matched case-insensitively using
+// path.Match, after normalization. File paths which are not glob patterns are
+// returned as is.
+func expandGlobs(archives []*d2mpq.MPQ, filePaths []string) ([]string, error) {
+	var expanded []string
+	var lists [][]string
+	for _, filePath := range filePaths {
+		if !isGlob(filePath) {
+			expanded = append(expanded, filePath)
+			continue
+		}
+		if lists == nil {
+			for _, archive := range archives {
+				files, err := archive.GetFileList()
+				if err != nil {
+					log.Printf("unable to read (listfile) of %q to expand glob patterns; %v\n", archive.FileName, err)
+					continue
+				}
+				lists = append(lists, files)
+			}
+		}
+		pattern := strings.TrimPrefix(strings.ToLower(normalize(filePath)), "/")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid glob pattern %q", filePath)
+		}
+		seen := make(map[string]bool)
+		for _, files := range lists {
+			for _, file := range files {
+				name := strings.ToLower(normalize(file))
+				if seen[name] {
+					continue
+				}
+				if ok, _ := path.Match(pattern, name); ok {
+					seen[name] = true
+					expanded = append(expanded, file)
+				}
+			}
+		}
+		if len(seen) == 0 {
+			log.Printf("file not found %q\n", filePath)
+		}
+	}
+	return expanded, nil
+}
@@ -1,9 +1,28 @@
 module github.com/OpenDiablo2/MpqViewer
 
-go 1.14
+go 1.20
 
 require (
+	bazil.org/fuse v0.0.0-20230120002735-62a210ff1fd5
+	github.com/JoshVarga/blast v0.0.0-20180421040937-681c804fb9f0
 	github.com/OpenDiablo2/OpenDiablo2 v0.0.0-20191112131808-bdda07f7e59b
 	github.com/mewkiz/pkg v0.0.0-20190919212034-518ade7978e2
 	github.com/pkg/errors v0.8.1
+	golang.org/x/sync v0.6.0
+	golang.org/x/sys v0.16.0
+	modernc.org/sqlite v1.29.5
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/go-gl/glfw v0.0.0-20190409004039-e6da0acd62b1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/hajimehoshi/ebiten v1.10.1-0.20191108205544-35436ea50457 // indirect
+	github.com/mattn/go-isatty v0.0.16 // indirect
+	github.com/mitchellh/go-homedir v1.1.0 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	modernc.org/libc v1.41.0 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.7.2 // indirect
 )
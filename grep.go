@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// parseGrepExts parses the comma-separated list of file extensions of
+// -grep-ext (e.g. ".txt,.tbl"); the leading dot is optional. A nil set is
+// returned if the list is empty.
+func parseGrepExts(s string) map[string]bool {
+	if len(s) == 0 {
+		return nil
+	}
+	exts := make(map[string]bool)
+	for _, ext := range strings.Split(s, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if len(ext) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts[ext] = true
+	}
+	return exts
+}
+
+// grepFiles prints the files of the embedded (listfile) of each MPQ archive
+// whose decompressed contents contain the given pattern, one per line prefixed
+// by the output directory name of the archive and a tab. When ignoreCase is
+// set, the pattern is matched case-insensitively (ASCII only). If exts is
+// non-nil, only files with the given extensions are searched. Files that fail
+// to read are logged and skipped.
+func grepFiles(archives []*d2mpq.MPQ, pattern string, ignoreCase bool, exts map[string]bool, opts options) error {
+	needle := []byte(pattern)
+	if ignoreCase {
+		needle = bytes.ToLower(needle)
+	}
+	for _, archive := range archives {
+		files, err := archive.GetFileList()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dir := archiveDir(archive, opts)
+		if opts.lower {
+			dir = strings.ToLower(dir)
+		}
+		for _, filePath := range files {
+			filePath = denormalize(filePath)
+			if len(filePath) == 0 || !archive.FileExists(filePath) {
+				continue
+			}
+			if exts != nil && !exts[strings.ToLower(path.Ext(normalize(filePath)))] {
+				continue
+			}
+			data, err := archiveReadFile(archive, filePath)
+			if err != nil {
+				if errors.Cause(err) == ErrFileRead {
+					log.Printf("file read error %q in %q; %+v\n", filePath, archive.FileName, err)
+					continue
+				}
+				return errors.WithStack(err)
+			}
+			if ignoreCase {
+				data = bytes.ToLower(data)
+			}
+			if !bytes.Contains(data, needle) {
+				continue
+			}
+			fmt.Printf("%s\t%s\n", dir, recordedPath(filePath, opts))
+		}
+	}
+	return nil
+}
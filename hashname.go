@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// printNameHash prints the MPQ hashes of the given file name in hex; the hash
+// table offset, name A and name B hashes, and the encryption key. The crypto
+// buffer must have been initialized using d2mpq.InitializeCryptoBuffer. For each of
+// the given MPQ archives, the hash table index at which lookup of the file name
+// starts is printed as well.
+func printNameHash(filePath string, mpqPaths []string, protected bool) error {
+	if len(filePath) == 0 {
+		return errors.New("empty file name")
+	}
+	key := archivePath(denormalize(filePath))
+	offset, hashA, hashB := d2mpq.HashFileName(key)
+	fmt.Printf("name:         %q\n", key)
+	fmt.Printf("table offset: 0x%08X\n", offset)
+	fmt.Printf("name A:       0x%08X\n", hashA)
+	fmt.Printf("name B:       0x%08X\n", hashB)
+	fmt.Printf("file key:     0x%08X\n", d2mpq.FileKey(key))
+	for _, mpqPath := range mpqPaths {
+		_, mpqPath := parseArchiveArg(mpqPath)
+		archive, err := loadArchive(mpqPath, protected)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		n := uint32(len(archive.HashTableEntries))
+		if n == 0 {
+			fmt.Printf("index:        (empty hash table) in %q\n", mpqPath)
+			continue
+		}
+		fmt.Printf("index:        0x%08X of 0x%08X in %q\n", offset%n, n, mpqPath)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// healthcheck loads each MPQ archive and verifies that it has a readable
+// (listfile), and returns the total number of listfile entries. Any error
+// is reported as a one-line reason.
+func healthcheck(mpqPaths []string, protected bool) (n int, err error) {
+	for _, mpqPath := range mpqPaths {
+		_, mpqPath := parseArchiveArg(mpqPath)
+		files, err := checkArchive(mpqPath, protected)
+		if err != nil {
+			return n, errors.Errorf("%s: %v", mpqPath, err)
+		}
+		n += files
+	}
+	return n, nil
+}
+
+// checkArchive loads the given MPQ archive and reads its (listfile), and
+// returns the number of listfile entries. Panics of d2mpq are reported as
+// errors.
+func checkArchive(mpqPath string, protected bool) (n int, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = errors.Errorf("%v", e)
+		}
+	}()
+	archive, err := loadArchive(mpqPath, protected)
+	if err != nil {
+		return 0, errors.Cause(err)
+	}
+	if !archive.FileExists("(listfile)") {
+		return 0, errors.New("no (listfile)")
+	}
+	files, err := archive.GetFileList()
+	if err != nil {
+		return 0, errors.Wrap(err, "unreadable (listfile)")
+	}
+	return len(files), nil
+}
+
+// healthStatus returns the one-line health status of the given healthcheck
+// result.
+func healthStatus(archives, files int, err error) string {
+	if err != nil {
+		return fmt.Sprintf("unhealthy: %v", err)
+	}
+	return fmt.Sprintf("healthy: %d archive(s), %d listfile entries", archives, files)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// writeTSV writes a tab-separated index of the normalized path and uncompressed
+// size of each file to the given path. Files not present in any of the MPQ
+// archives are skipped.
+func writeTSV(archives []*d2mpq.MPQ, filePaths []string, tsvPath string, opts options) error {
+	f, err := os.Create(tsvPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for _, filePath := range filePaths {
+		size, ok := lookupFileSize(archives, filePath)
+		if !ok {
+			log.Printf("file not found %q\n", filePath)
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%d\n", recordedPath(filePath, opts), size); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeCSVIndex writes a CSV index of each file of the MPQ archives to the
+// given path, with columns for the output directory name of the MPQ archive,
+// the file path, the uncompressed and compressed size, the compression ratio
+// (compressed size divided by uncompressed size), whether the file is encrypted
+// and the byte offset of the file within the MPQ archive. A file present in
+// several MPQ archives is listed once per MPQ archive; duplicate file paths are
+// skipped.
+func writeCSVIndex(archives []*d2mpq.MPQ, filePaths []string, csvPath string, opts options) error {
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	header := []string{"archive", "path", "size", "compressed_size", "ratio", "encrypted", "offset"}
+	if err := w.Write(header); err != nil {
+		return errors.WithStack(err)
+	}
+	seen := make(map[string]bool)
+	for _, filePath := range filePaths {
+		key := archivePath(filePath)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		found := false
+		for _, archive := range archives {
+			block, ok := archive.FileBlock(key)
+			if !ok {
+				continue
+			}
+			found = true
+			ratio := 0.0
+			if block.UncompressedFileSize > 0 {
+				ratio = float64(block.CompressedFileSize) / float64(block.UncompressedFileSize)
+			}
+			record := []string{
+				archiveDir(archive, opts),
+				recordedPath(filePath, opts),
+				strconv.FormatUint(uint64(block.UncompressedFileSize), 10),
+				strconv.FormatUint(uint64(block.CompressedFileSize), 10),
+				strconv.FormatFloat(ratio, 'f', 3, 64),
+				strconv.FormatBool(block.HasFlag(d2mpq.FileEncrypted)),
+				strconv.FormatInt(block.Position(), 10),
+			}
+			if err := w.Write(record); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		if !found {
+			log.Printf("file not found %q\n", filePath)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// lookupFileSize returns the uncompressed size of the given file in the first
+// MPQ archive containing the file path.
+func lookupFileSize(archives []*d2mpq.MPQ, filePath string) (uint32, bool) {
+	filePath = archivePath(filePath)
+	for _, archive := range archives {
+		if size, ok := archive.FileSize(filePath); ok {
+			return size, true
+		}
+	}
+	return 0, false
+}
+
+// extStats records the number of files and total uncompressed size of files
+// with a given extension.
+type extStats struct {
+	// File extension (without leading dot).
+	ext string
+	// Number of files.
+	count int
+	// Total uncompressed size in bytes.
+	size int64
+}
+
+// printExtReport prints a tally of the number of files and total uncompressed
+// size per file extension, sorted by number of files in descending order.
+func printExtReport(archives []*d2mpq.MPQ, filePaths []string) {
+	stats := make(map[string]*extStats)
+	for _, filePath := range filePaths {
+		size, ok := lookupFileSize(archives, filePath)
+		if !ok {
+			continue
+		}
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(normalize(filePath)), "."))
+		if len(ext) == 0 {
+			ext = "(none)"
+		}
+		s, ok := stats[ext]
+		if !ok {
+			s = &extStats{ext: ext}
+			stats[ext] = s
+		}
+		s.count++
+		s.size += int64(size)
+	}
+	var sorted []*extStats
+	for _, s := range stats {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].ext < sorted[j].ext
+	})
+	for _, s := range sorted {
+		fmt.Printf("%s: %d (%d bytes)\n", s.ext, s.count, s.size)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+)
+
+// printInfo prints the header fields of each MPQ archive; the format version,
+// header and archive size, sector size, and the offset and number of entries of
+// the hash and block tables. Table offsets are absolute offsets within the
+// file.
+func printInfo(archives []*d2mpq.MPQ) {
+	for i, archive := range archives {
+		if i > 0 {
+			fmt.Println()
+		}
+		data := archive.Data
+		fmt.Printf("archive:        %q\n", archive.FileName)
+		fmt.Printf("format version: %d (v%d)\n", data.FormatVersion, data.FormatVersion+1)
+		fmt.Printf("header size:    %d\n", data.HeaderSize)
+		if archive.HeaderOffset != 0 {
+			fmt.Printf("header offset:  0x%08X\n", archive.HeaderOffset)
+		}
+		fmt.Printf("archive size:   %d\n", data.ArchiveSize)
+		fmt.Printf("sector size:    %d (shift %d)\n", archive.SectorSize(), data.BlockSize)
+		fmt.Printf("hash table:     offset 0x%08X, %d entries\n", archive.HashTablePosition(), data.HashTableEntries)
+		fmt.Printf("block table:    offset 0x%08X, %d entries\n", archive.BlockTablePosition(), data.BlockTableEntries)
+		if data.FormatVersion >= d2mpq.FormatVersion2 && archive.DataV2.HiBlockTableOffset != 0 {
+			fmt.Printf("hi-block table: offset 0x%08X\n", archive.HeaderOffset+int64(archive.DataV2.HiBlockTableOffset))
+		}
+		if archive.UserData.Magic != [4]byte{} {
+			fmt.Printf("user data:      %d bytes\n", archive.UserData.UserDataSize)
+		}
+		for _, repair := range archive.Repairs {
+			fmt.Printf("repair:         %s\n", repair)
+		}
+	}
+}
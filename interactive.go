@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// Maximum number of bytes shown in hex previews of binary files.
+const maxHexPreview = 4096
+
+// browser is the state of the interactive file browser of -interactive.
+type browser struct {
+	// MPQ archives of browsed files.
+	archives []*d2mpq.MPQ
+	// Sink of extracted files.
+	sink Sink
+	opts options
+	// Directories from the root to the current directory.
+	dirs []*treeNode
+	// Selected entry of each parent directory, restored when navigating back.
+	cursors []int
+	// Sorted entries of the current directory.
+	entries []*treeNode
+	// Index of selected entry, and of first visible entry.
+	cursor, offset int
+	// Preview lines of the selected file, cached by node.
+	previewNode *treeNode
+	preview     []string
+	// Status message shown in the bottom line.
+	status string
+}
+
+// browse runs an interactive terminal browser of the directory tree of the
+// given files of the MPQ archives. The selected file is previewed as text or
+// hex dump, and extracted to outDir on request.
+func browse(archives []*d2mpq.MPQ, filePaths []string, outDir string, opts options) (err error) {
+	if len(filePaths) == 0 {
+		return errors.New("no files to browse")
+	}
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer restore()
+	sink, err := newSink(outDir, "", opts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if e := sink.Close(); err == nil {
+			err = errors.WithStack(e)
+		}
+	}()
+	// Suppress per-file output of extraction, which would garble the screen.
+	prevQuiet := quiet
+	quiet = true
+	defer func() { quiet = prevQuiet }()
+
+	b := &browser{
+		archives: archives,
+		sink:     sink,
+		opts:     opts,
+		status:   "arrows: navigate, enter: open, x: extract, q: quit",
+	}
+	b.enter(buildTree(filePaths, opts.lower))
+	w := bufio.NewWriter(os.Stdout)
+	// Switch to alternate screen and hide cursor.
+	fmt.Fprint(w, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(w, "\x1b[?25h\x1b[?1049l")
+		w.Flush()
+	}()
+	buf := make([]byte, 16)
+	for {
+		width, height := termSize(int(os.Stdout.Fd()))
+		b.draw(w, width, height)
+		if err := w.Flush(); err != nil {
+			return errors.WithStack(err)
+		}
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		pageSize := listHeight(height)
+		switch string(buf[:n]) {
+		case "q", "\x03", "\x1b":
+			return nil
+		case "\x1b[A", "k":
+			b.move(-1)
+		case "\x1b[B", "j":
+			b.move(1)
+		case "\x1b[5~":
+			b.move(-pageSize)
+		case "\x1b[6~":
+			b.move(pageSize)
+		case "\x1b[C", "\r", "l":
+			b.open()
+		case "\x1b[D", "\x7f", "h":
+			b.back()
+		case "x":
+			b.extract()
+		}
+	}
+}
+
+// enter makes the given directory the current directory.
+func (b *browser) enter(dir *treeNode) {
+	b.dirs = append(b.dirs, dir)
+	b.cursors = append(b.cursors, b.cursor)
+	b.entries = dir.sortedChildren()
+	b.cursor, b.offset = 0, 0
+}
+
+// back makes the parent directory the current directory, selecting the
+// directory navigated from.
+func (b *browser) back() {
+	if len(b.dirs) < 2 {
+		return
+	}
+	b.cursor = b.cursors[len(b.cursors)-1]
+	b.dirs = b.dirs[:len(b.dirs)-1]
+	b.cursors = b.cursors[:len(b.cursors)-1]
+	b.entries = b.dirs[len(b.dirs)-1].sortedChildren()
+	b.offset = 0
+}
+
+// move moves the selection by the given number of entries.
+func (b *browser) move(delta int) {
+	b.cursor += delta
+	if b.cursor >= len(b.entries) {
+		b.cursor = len(b.entries) - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+}
+
+// selected returns the selected entry of the current directory; or nil if the
+// directory is empty.
+func (b *browser) selected() *treeNode {
+	if b.cursor >= len(b.entries) {
+		return nil
+	}
+	return b.entries[b.cursor]
+}
+
+// open enters the selected directory.
+func (b *browser) open() {
+	if node := b.selected(); node != nil && node.isDir() {
+		b.enter(node)
+	}
+}
+
+// extract extracts the selected file, or all files below the selected
+// directory, and reports the outcome in the status line.
+func (b *browser) extract() {
+	node := b.selected()
+	if node == nil {
+		return
+	}
+	var filePaths []string
+	collectFiles(node, &filePaths)
+	var total, extracted int
+	for _, filePath := range filePaths {
+		n, written, err := extractFile(b.archives, filePath, b.sink, b.opts)
+		if err != nil {
+			b.status = fmt.Sprintf("unable to extract %q; %v", normalize(filePath), err)
+			return
+		}
+		if written {
+			extracted++
+		}
+		total += n
+	}
+	b.status = fmt.Sprintf("extracted %d file(s) (%d bytes), skipped %d of %q", extracted, total, len(filePaths)-extracted, node.name)
+}
+
+// collectFiles appends the file paths of the given node and its descendants to
+// filePaths.
+func collectFiles(node *treeNode, filePaths *[]string) {
+	if !node.isDir() {
+		*filePaths = append(*filePaths, node.filePath)
+		return
+	}
+	for _, child := range node.sortedChildren() {
+		collectFiles(child, filePaths)
+	}
+}
+
+// listHeight returns the number of entries shown in a terminal of the given
+// height; the top half of the screen below the header line.
+func listHeight(height int) int {
+	if n := (height - 3) / 2; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// draw draws the header, entries of the current directory, preview of the
+// selected file and status line to the terminal of the given size.
+func (b *browser) draw(w io.Writer, width, height int) {
+	rows := listHeight(height)
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	}
+	if b.cursor >= b.offset+rows {
+		b.offset = b.cursor - rows + 1
+	}
+	var dirPath []string
+	for _, dir := range b.dirs[1:] {
+		dirPath = append(dirPath, dir.name)
+	}
+	fmt.Fprint(w, "\x1b[H")
+	header := fmt.Sprintf(" /%s (%d entries)", strings.Join(dirPath, "/"), len(b.entries))
+	fmt.Fprintf(w, "\x1b[7m%s\x1b[K\x1b[0m\r\n", fitLine(header, width))
+	for i := b.offset; i < b.offset+rows; i++ {
+		if i >= len(b.entries) {
+			fmt.Fprint(w, "\x1b[K\r\n")
+			continue
+		}
+		node := b.entries[i]
+		name := node.name
+		if node.isDir() {
+			name += "/"
+		}
+		line := fitLine("  "+name, width)
+		if i == b.cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		fmt.Fprintf(w, "%s\x1b[K\r\n", line)
+	}
+	fmt.Fprintf(w, "%s\x1b[K\r\n", strings.Repeat("-", width))
+	preview := b.previewLines()
+	for i := 0; i < height-rows-3; i++ {
+		line := ""
+		if i < len(preview) {
+			line = fitLine(preview[i], width)
+		}
+		fmt.Fprintf(w, "%s\x1b[K\r\n", line)
+	}
+	fmt.Fprintf(w, "\x1b[7m%s\x1b[K\x1b[0m", fitLine(" "+b.status, width))
+}
+
+// previewLines returns the preview lines of the selected entry; the contents of
+// text files, a hex dump of other files, and the number of entries of
+// directories.
+func (b *browser) previewLines() []string {
+	node := b.selected()
+	if node == nil {
+		return nil
+	}
+	if node == b.previewNode {
+		return b.preview
+	}
+	b.previewNode = node
+	switch {
+	case node.isDir():
+		b.preview = []string{fmt.Sprintf("directory; %d entries", len(node.children))}
+	default:
+		data, archive, err := readFile(b.archives, node.filePath, b.opts)
+		if err != nil {
+			b.preview = []string{fmt.Sprintf("unable to read %q; %v", normalize(node.filePath), errors.Cause(err))}
+			break
+		}
+		header := fmt.Sprintf("%s (%d bytes) in %q", normalize(node.filePath), len(data), archive.FileName)
+		if isText(data) {
+			text := strings.ReplaceAll(string(data), "\r\n", "\n")
+			b.preview = append([]string{header}, strings.Split(text, "\n")...)
+			break
+		}
+		if len(data) > maxHexPreview {
+			data = data[:maxHexPreview]
+		}
+		dump := strings.TrimSuffix(hex.Dump(data), "\n")
+		b.preview = append([]string{header}, strings.Split(dump, "\n")...)
+	}
+	return b.preview
+}
+
+// fitLine returns the given line with tabs expanded and control characters
+// removed, truncated to the given width.
+func fitLine(s string, width int) string {
+	s = strings.ReplaceAll(s, "\t", "    ")
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7F {
+			return -1
+		}
+		return r
+	}, s)
+	if r := []rune(s); len(r) > width {
+		s = string(r[:width])
+	}
+	return s
+}
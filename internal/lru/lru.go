@@ -0,0 +1,85 @@
+// Package lru implements an LRU cache of byte slices, bounded by their total
+// size in bytes.
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+// entry is a cached value.
+type entry[K comparable] struct {
+	key  K
+	data []byte
+}
+
+// Cache is an LRU cache of byte slices by key, bounded by the total size in
+// bytes of cached values. It is safe for concurrent use.
+type Cache[K comparable] struct {
+	mu sync.Mutex
+	// Maximum total size in bytes of cached values.
+	maxSize int64
+	// Total size in bytes of cached values.
+	size int64
+	// Cached values, most recently used first.
+	lru *list.List
+	// Cached values by key.
+	entries map[K]*list.Element
+}
+
+// New returns a new LRU cache holding at most maxSize bytes.
+func New[K comparable](maxSize int64) *Cache[K] {
+	return &Cache[K]{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[K]*list.Element),
+	}
+}
+
+// Get returns the cached value of the given key, and a boolean indicating
+// whether the key was cached. The returned value must not be modified.
+func (c *Cache[K]) Get(key K) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*entry[K]).data, true
+}
+
+// Put caches the value of the given key, replacing any previously cached value
+// of the key, and evicts the least recently used values as needed to stay
+// within the size limit. Values larger than the size limit are not cached.
+func (c *Cache[K]) Put(key K, data []byte) {
+	size := int64(len(data))
+	if size > c.maxSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(&entry[K]{key: key, data: data})
+	c.size += size
+}
+
+// remove removes the given cached value. The caller must hold c.mu.
+func (c *Cache[K]) remove(elem *list.Element) {
+	e := elem.Value.(*entry[K])
+	c.size -= int64(len(e.data))
+	c.lru.Remove(elem)
+	delete(c.entries, e.key)
+}
+
+// Size returns the total size in bytes of cached values.
+func (c *Cache[K]) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
@@ -0,0 +1,104 @@
+package lru
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	type op struct {
+		// Key to put, or get if data is nil.
+		key  string
+		data []byte
+	}
+	golden := []struct {
+		name    string
+		maxSize int64
+		ops     []op
+		// Expected cached keys, and expected total size.
+		want     []string
+		wantSize int64
+	}{
+		{
+			name:     "within limit",
+			maxSize:  8,
+			ops:      []op{{key: "a", data: []byte("aaa")}, {key: "b", data: []byte("bbb")}},
+			want:     []string{"a", "b"},
+			wantSize: 6,
+		},
+		{
+			name:     "evict least recently put",
+			maxSize:  8,
+			ops:      []op{{key: "a", data: []byte("aaa")}, {key: "b", data: []byte("bbb")}, {key: "c", data: []byte("ccc")}},
+			want:     []string{"b", "c"},
+			wantSize: 6,
+		},
+		{
+			name:     "evict least recently used",
+			maxSize:  8,
+			ops:      []op{{key: "a", data: []byte("aaa")}, {key: "b", data: []byte("bbb")}, {key: "a"}, {key: "c", data: []byte("ccc")}},
+			want:     []string{"a", "c"},
+			wantSize: 6,
+		},
+		{
+			name:     "replace",
+			maxSize:  8,
+			ops:      []op{{key: "a", data: []byte("aaa")}, {key: "a", data: []byte("aaaaa")}},
+			want:     []string{"a"},
+			wantSize: 5,
+		},
+		{
+			name:     "larger than limit",
+			maxSize:  4,
+			ops:      []op{{key: "a", data: []byte("aaa")}, {key: "b", data: []byte("bbbbb")}},
+			want:     []string{"a"},
+			wantSize: 3,
+		},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			c := New[string](g.maxSize)
+			for _, op := range g.ops {
+				if op.data == nil {
+					c.Get(op.key)
+					continue
+				}
+				c.Put(op.key, op.data)
+			}
+			var got []string
+			for _, key := range []string{"a", "b", "c"} {
+				if _, ok := c.Get(key); ok {
+					got = append(got, key)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(g.want, ",") {
+				t.Errorf("cached keys mismatch; expected %q, got %q", g.want, got)
+			}
+			if size := c.Size(); size != g.wantSize {
+				t.Errorf("size mismatch; expected %d, got %d", g.wantSize, size)
+			}
+		})
+	}
+}
+
+// TestCacheConcurrent uses the cache from many goroutines; run with -race to
+// detect data races.
+func TestCacheConcurrent(t *testing.T) {
+	c := New[int](64)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Put((i+j)%32, make([]byte, 8))
+				c.Get(j % 32)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if size := c.Size(); size > 64 {
+		t.Errorf("size %d exceeds limit of 64 bytes", size)
+	}
+}
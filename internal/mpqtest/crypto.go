@@ -0,0 +1,56 @@
+package mpqtest
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// cryptTable is the table of MPQ hashing and encryption.
+var cryptTable = func() [0x500]uint32 {
+	var t [0x500]uint32
+	seed := uint32(0x00100001)
+	for i := 0; i < 0x100; i++ {
+		for j := i; j < 0x500; j += 0x100 {
+			seed = (seed*125 + 3) % 0x2AAAAB
+			hi := (seed & 0xFFFF) << 16
+			seed = (seed*125 + 3) % 0x2AAAAB
+			t[j] = hi | seed&0xFFFF
+		}
+	}
+	return t
+}()
+
+// hashString returns the MPQ hash of the given key using the specified hash
+// type.
+func hashString(key string, hashType uint32) uint32 {
+	seed1, seed2 := uint32(0x7FED7FED), uint32(0xEEEEEEEE)
+	for _, c := range strings.ToUpper(key) {
+		seed1 = cryptTable[hashType*0x100+uint32(c)] ^ (seed1 + seed2)
+		seed2 = uint32(c) + seed1 + seed2 + seed2<<5 + 3
+	}
+	return seed1
+}
+
+// encrypt encrypts the given words in place using the specified key.
+func encrypt(data []uint32, key uint32) {
+	seed := uint32(0xEEEEEEEE)
+	for i, plain := range data {
+		seed += cryptTable[0x400+key&0xFF]
+		data[i] = plain ^ (key + seed)
+		key = (^key<<21 + 0x11111111) | key>>11
+		seed = plain + seed + seed<<5 + 3
+	}
+}
+
+// encryptBytes encrypts the given data in place using the specified key. A
+// trailing partial word is left unencrypted.
+func encryptBytes(data []byte, key uint32) {
+	words := make([]uint32, len(data)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+	encrypt(words, key)
+	for i, w := range words {
+		binary.LittleEndian.PutUint32(data[4*i:], w)
+	}
+}
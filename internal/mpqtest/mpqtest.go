@@ -0,0 +1,381 @@
+// Package mpqtest builds MPQ archives for tests.
+//
+// The archives are built from scratch, independently of the d2mpq package, so
+// that tests of d2mpq are not tautological.
+package mpqtest
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshVarga/blast"
+)
+
+// Compression method masks of compressed sectors supported by the builder.
+const (
+	CompressionZlib   = 0x02
+	CompressionPKLib  = 0x08
+	CompressionSparse = 0x20
+)
+
+// Flags of block table entries.
+const (
+	flagImplode    = 0x00000100
+	flagCompress   = 0x00000200
+	flagEncrypted  = 0x00010000
+	flagFixKey     = 0x00020000
+	flagSingleUnit = 0x01000000
+	flagExists     = 0x80000000
+)
+
+// headerAlignment is the alignment of MPQ headers within files.
+const headerAlignment = 0x200
+
+// File is a file of an MPQ archive.
+type File struct {
+	// Archive path of the file (e.g. `data\global\excel\books.txt`).
+	Name string
+	// Uncompressed contents of the file.
+	Data []byte
+	// Compression method mask of sectors (e.g. CompressionZlib); 0 if not
+	// compressed using FileCompress.
+	Compression byte
+	// Compress sectors using PKWARE DCL implode (FileImplode), without any
+	// compression mask. If Compression is also set, both flags are set, but
+	// sectors are compressed according to Compression.
+	Implode bool
+	// Encrypt the file (and its sector offset table).
+	Encrypted bool
+	// Adjust the encryption key by the file position and size (FileFixKey).
+	FixKey bool
+	// Store the file as a single unit rather than as sectors.
+	SingleUnit bool
+	// Locale of the hash table entry.
+	Locale uint16
+	// Uncompressed size recorded in the block table entry; the length of Data
+	// if 0.
+	Size uint32
+	// Modification time recorded in the (attributes) file; unknown if zero.
+	ModTime time.Time
+	// High 16 bits of the file position recorded in the hi-block table of
+	// format version 2; the file contents are stored at the low 32 bits
+	// regardless.
+	PositionHi uint16
+}
+
+// Archive is an MPQ archive.
+type Archive struct {
+	Files []File
+	// Sector size shift; sectors are 0x200<<SectorShift bytes.
+	SectorShift uint16
+	// Number of hash table entries; a power of two. Defaults to the smallest
+	// power of two of at least twice the number of files, and at least 4.
+	HashTableSize uint32
+	// Write a header of format version 2, followed by a hi-block table.
+	V2 bool
+	// Data preceding the MPQ header (e.g. a data fork); padded with zeros to
+	// the header alignment.
+	Prefix []byte
+	// Precede the MPQ header by a user-data header, recording the offset of
+	// the MPQ header.
+	UserData bool
+	// Add a (listfile) of all files.
+	Listfile bool
+	// Add an (attributes) file of the CRC32 and modification time of all
+	// files.
+	Attributes bool
+}
+
+// Write writes the MPQ archive to the given file name within dir, and returns
+// the path to the MPQ archive.
+func (a Archive) Write(t testing.TB, dir, name string) string {
+	t.Helper()
+	mpqPath := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(mpqPath, a.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return mpqPath
+}
+
+// Bytes returns the contents of the MPQ archive.
+func (a Archive) Bytes() []byte {
+	files := append([]File(nil), a.Files...)
+	if a.Listfile {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		files = append(files, File{Name: "(listfile)", Data: []byte(strings.Join(names, "\r\n") + "\r\n"), Compression: CompressionZlib})
+	}
+	if a.Attributes {
+		files = append(files, File{Name: "(attributes)", Data: attributes(files), Compression: CompressionZlib})
+	}
+	hashSize := a.HashTableSize
+	if hashSize == 0 {
+		hashSize = 4
+		for hashSize < 2*uint32(len(files)) {
+			hashSize *= 2
+		}
+	}
+	headerSize := uint32(32)
+	if a.V2 {
+		headerSize = 44
+	}
+	sectorSize := uint32(0x200) << a.SectorShift
+
+	// Files, relative to the MPQ header.
+	body := new(bytes.Buffer)
+	var blocks []uint32
+	for _, f := range files {
+		pos := headerSize + uint32(body.Len())
+		blob, size, flags := f.encode(pos, sectorSize)
+		body.Write(blob)
+		blocks = append(blocks, pos, uint32(len(blob)), size, flags)
+	}
+
+	// Hash table.
+	hashTable := make([]uint32, 4*hashSize)
+	for i := range hashTable {
+		hashTable[i] = 0xFFFFFFFF
+	}
+	for blockIndex, f := range files {
+		i := hashString(f.Name, 0) % hashSize
+		for hashTable[4*i+3] != 0xFFFFFFFF {
+			i = (i + 1) % hashSize
+		}
+		hashTable[4*i] = hashString(f.Name, 1)
+		hashTable[4*i+1] = hashString(f.Name, 2)
+		hashTable[4*i+2] = uint32(f.Locale)
+		hashTable[4*i+3] = uint32(blockIndex)
+	}
+	encrypt(hashTable, hashString("(hash table)", 3))
+	encrypt(blocks, hashString("(block table)", 3))
+	hashPos := headerSize + uint32(body.Len())
+	blockPos := hashPos + 16*hashSize
+	archiveSize := blockPos + 4*uint32(len(blocks))
+	hiBlockPos := archiveSize
+	if a.V2 {
+		archiveSize += 2 * uint32(len(files))
+	}
+
+	out := new(bytes.Buffer)
+	out.Write(a.Prefix)
+	if pad := out.Len() % headerAlignment; pad != 0 {
+		out.Write(make([]byte, headerAlignment-pad))
+	}
+	if a.UserData {
+		// MPQ header follows at the next header alignment.
+		out.WriteString("MPQ\x1B")
+		binary.Write(out, binary.LittleEndian, []uint32{headerAlignment - 16, headerAlignment, 16})
+		out.Write(make([]byte, headerAlignment-16))
+	}
+	out.WriteString("MPQ\x1A")
+	binary.Write(out, binary.LittleEndian, []uint32{headerSize, archiveSize})
+	binary.Write(out, binary.LittleEndian, []uint16{uint16(boolToInt(a.V2)), a.SectorShift})
+	binary.Write(out, binary.LittleEndian, []uint32{hashPos, blockPos, hashSize, uint32(len(files))})
+	if a.V2 {
+		binary.Write(out, binary.LittleEndian, uint64(hiBlockPos))
+		binary.Write(out, binary.LittleEndian, []uint16{0, 0})
+	}
+	out.Write(body.Bytes())
+	binary.Write(out, binary.LittleEndian, hashTable)
+	binary.Write(out, binary.LittleEndian, blocks)
+	if a.V2 {
+		for _, f := range files {
+			binary.Write(out, binary.LittleEndian, f.PositionHi)
+		}
+	}
+	return out.Bytes()
+}
+
+// encode returns the stored contents of the file at the given position
+// relative to the MPQ header, along with the uncompressed size and flags of its
+// block table entry.
+func (f File) encode(pos, sectorSize uint32) (blob []byte, size, flags uint32) {
+	size = f.Size
+	if size == 0 {
+		size = uint32(len(f.Data))
+	}
+	flags = flagExists
+	if f.Compression != 0 {
+		flags |= flagCompress
+	}
+	if f.Implode {
+		flags |= flagImplode
+	}
+	key := uint32(0)
+	if f.Encrypted {
+		flags |= flagEncrypted
+		segs := strings.Split(f.Name, `\`)
+		key = hashString(segs[len(segs)-1], 3)
+		if f.FixKey {
+			flags |= flagFixKey
+			key = (key + pos) ^ size
+		}
+	}
+	if f.SingleUnit {
+		flags |= flagSingleUnit
+		blob = f.compress(f.Data)
+		if f.Encrypted {
+			encryptBytes(blob, key)
+		}
+		return blob, size, flags
+	}
+	raw := append([]byte(nil), f.Data...)
+	var sectors [][]byte
+	for i := 0; i < len(raw); i += int(sectorSize) {
+		end := i + int(sectorSize)
+		if end > len(raw) {
+			end = len(raw)
+		}
+		sectors = append(sectors, raw[i:end])
+	}
+	if flags&(flagCompress|flagImplode) == 0 {
+		for i, sector := range sectors {
+			if f.Encrypted {
+				encryptBytes(sector, key+uint32(i))
+			}
+			blob = append(blob, sector...)
+		}
+		return blob, size, flags
+	}
+	// Sector offset table, followed by sectors.
+	offsets := []uint32{4 * uint32(len(sectors)+1)}
+	var data []byte
+	for i, sector := range sectors {
+		sector = f.compress(sector)
+		if f.Encrypted {
+			encryptBytes(sector, key+uint32(i))
+		}
+		data = append(data, sector...)
+		offsets = append(offsets, offsets[0]+uint32(len(data)))
+	}
+	if f.Encrypted {
+		encrypt(offsets, key-1)
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, offsets)
+	buf.Write(data)
+	return buf.Bytes(), size, flags
+}
+
+// compress compresses the given sector (or single unit file) according to the
+// compression method of the file. As done by Storm, the sector is stored
+// uncompressed if compression does not shrink it.
+func (f File) compress(raw []byte) []byte {
+	var out []byte
+	switch {
+	case f.Compression != 0:
+		out = compressMulti(raw, f.Compression)
+	case f.Implode:
+		out = implode(raw)
+	default:
+		return append([]byte(nil), raw...)
+	}
+	if len(out) >= len(raw) {
+		return append([]byte(nil), raw...)
+	}
+	return out
+}
+
+// compressMulti compresses the given sector using each method of the
+// compression mask in turn, and prefixes the compression mask.
+func compressMulti(raw []byte, mask byte) []byte {
+	data := raw
+	if mask&CompressionSparse != 0 {
+		data = sparse(data)
+	}
+	if mask&CompressionZlib != 0 {
+		buf := new(bytes.Buffer)
+		w := zlib.NewWriter(buf)
+		w.Write(data)
+		w.Close()
+		data = buf.Bytes()
+	}
+	if mask&CompressionPKLib != 0 {
+		data = implode(data)
+	}
+	if mask&^(CompressionSparse|CompressionZlib|CompressionPKLib) != 0 {
+		panic(fmt.Sprintf("mpqtest: unsupported compression mask 0x%02X", mask))
+	}
+	return append([]byte{mask}, data...)
+}
+
+// implode compresses the given data using PKWARE DCL implode.
+func implode(data []byte) []byte {
+	buf := new(bytes.Buffer)
+	w := blast.NewWriter(buf, blast.Binary, blast.DictionarySize1024)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// sparse compresses the given data using sparse compression, which run-length
+// encodes runs of zero bytes.
+func sparse(data []byte) []byte {
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint32(out, uint32(len(data)))
+	for i := 0; i < len(data); {
+		// Run of at least 3 zero bytes.
+		j := i
+		for j < len(data) && data[j] == 0 && j-i < 0x7F+3 {
+			j++
+		}
+		if j-i >= 3 {
+			out = append(out, byte(j-i-3))
+			i = j
+			continue
+		}
+		// Verbatim bytes, up to the next run of zero bytes.
+		j = i + 1
+		for j < len(data) && j-i < 0x80 && !bytes.HasPrefix(data[j:], []byte{0, 0, 0}) {
+			j++
+		}
+		out = append(out, 0x80|byte(j-i-1))
+		out = append(out, data[i:j]...)
+		i = j
+	}
+	return out
+}
+
+// attributes returns the contents of an (attributes) file of the CRC32 and
+// modification time of the given files, followed by the (attributes) file
+// itself.
+func attributes(files []File) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, []uint32{100, 0x1 | 0x2})
+	for _, f := range files {
+		binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(f.Data))
+	}
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+	for _, f := range files {
+		binary.Write(buf, binary.LittleEndian, fileTime(f.ModTime))
+	}
+	binary.Write(buf, binary.LittleEndian, uint64(0))
+	return buf.Bytes()
+}
+
+// fileTime returns the given time as a Windows FILETIME; the number of 100
+// nanosecond intervals since January 1, 1601 (UTC). The zero time is 0.
+func fileTime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	const epochDelta = 116444736000000000
+	return uint64(t.UnixNano()/100) + epochDelta
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
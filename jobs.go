@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// archiveLocks maps from MPQ archive to the lock serializing reads from the
+// archive, as d2mpq.MPQ is not safe for concurrent use. The map is populated up
+// front and only read thereafter, so it may be used from multiple goroutines.
+//
+// Each lock is a channel of capacity 1 which holds a value while locked, so
+// that waiting for a lock may be abandoned (see lockContext).
+type archiveLocks map[*d2mpq.MPQ]chan struct{}
+
+// newArchiveLocks returns per-archive locks of the given MPQ archives.
+func newArchiveLocks(archives []*d2mpq.MPQ) archiveLocks {
+	locks := make(archiveLocks)
+	for _, archive := range archives {
+		locks[archive] = make(chan struct{}, 1)
+	}
+	return locks
+}
+
+// lock locks the given MPQ archive; a no-op if locks is nil.
+func (locks archiveLocks) lock(archive *d2mpq.MPQ) {
+	if l, ok := locks[archive]; ok {
+		l <- struct{}{}
+	}
+}
+
+// lockContext locks the given MPQ archive, or returns the error of the context
+// if it is done before the lock is acquired; a no-op if locks is nil.
+func (locks archiveLocks) lockContext(ctx context.Context, archive *d2mpq.MPQ) error {
+	l, ok := locks[archive]
+	if !ok {
+		return nil
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// unlock unlocks the given MPQ archive; a no-op if locks is nil.
+func (locks archiveLocks) unlock(archive *d2mpq.MPQ) {
+	if l, ok := locks[archive]; ok {
+		<-l
+	}
+}
+
+// extractResult is the result of extracting a file.
+type extractResult struct {
+	// File path.
+	filePath string
+	// Number of bytes extracted.
+	n int64
+	// File found in any of the MPQ archives.
+	found bool
+	// Number of groups of MPQ archives the file was extracted from.
+	extracted int
+	// Number of groups of MPQ archives the file was skipped from; e.g. by
+	// -type, -no-clobber or -resume-extract.
+	skipped int
+	// Number of file read errors skipped.
+	readErrors int
+	// Number of other errors skipped, with -continue-on-error.
+	failed int
+	// Extraction error.
+	err error
+}
+
+// preloadAttributes reads the (attributes) file of each MPQ archive of the
+// groups, which is memoized by the MPQ archive. Reading it on first use from a
+// worker would race with the reads of other workers, which only lock the MPQ
+// archive for the duration of reading file contents. Errors are reported when
+// the (attributes) file is used.
+func preloadAttributes(groups [][]*d2mpq.MPQ) {
+	for _, group := range groups {
+		for _, archive := range group {
+			archive.Attributes()
+		}
+	}
+}
+
+// extractAllFilesParallel extracts all files specified by file path from the
+// groups of MPQ archives using a pool of opts.jobs workers, and returns a
+// summary of the extraction results.
+//
+// Reads from each MPQ archive are serialized using opts.locks. Files not found
+// and file read errors are skipped as done by extractAllFiles; other errors do
+// not abort the extraction, but are collected and reported once all files have
+// been processed.
+func extractAllFilesParallel(groups [][]*d2mpq.MPQ, filePaths []string, sink Sink, opts options) (extractSummary, error) {
+	if opts.verify != nil || opts.modTimes != nil {
+		preloadAttributes(groups)
+	}
+	paths := make(chan string)
+	results := make(chan extractResult)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range paths {
+				results <- extractFileGroups(groups, filePath, sink, opts)
+			}
+		}()
+	}
+	go func() {
+		for _, filePath := range filePaths {
+			paths <- filePath
+		}
+		close(paths)
+		wg.Wait()
+		close(results)
+	}()
+	var summary extractSummary
+	var errs []string
+	for result := range results {
+		summary.add(result)
+		switch {
+		case result.err != nil:
+			errs = append(errs, fmt.Sprintf("%q: %v", result.filePath, result.err))
+		case !result.found:
+			recordNotFound(result.filePath, opts)
+		}
+	}
+	if len(errs) > 0 {
+		return summary, errors.Errorf("extraction of %d file(s) failed:\n\t%s", len(errs), strings.Join(errs, "\n\t"))
+	}
+	if err := summary.failure(opts); err != nil {
+		return summary, errors.WithStack(err)
+	}
+	return summary, nil
+}
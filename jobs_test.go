@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/OpenDiablo2/MpqViewer/internal/mpqtest"
+)
+
+// memSink is an in-memory sink of extracted files, which panics when writing
+// the file of panicPath.
+type memSink struct {
+	mu        sync.Mutex
+	panicPath string
+	files     map[string][]byte
+}
+
+// WriteFile records the contents of the given file.
+func (s *memSink) WriteFile(archiveDir, filePath string, data []byte) error {
+	if filePath == s.panicPath {
+		panic("injected panic")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[filePath] = data
+	return nil
+}
+
+// Close is a no-op.
+func (s *memSink) Close() error {
+	return nil
+}
+
+func TestExtractAllFilesParallelPanic(t *testing.T) {
+	const fileCount = 32
+	var files []mpqtest.File
+	var filePaths []string
+	for i := 0; i < fileCount; i++ {
+		name := fmt.Sprintf(`data\global\excel\file%02d.txt`, i)
+		files = append(files, mpqtest.File{Name: name, Data: []byte(name), Compression: mpqtest.CompressionZlib})
+		filePaths = append(filePaths, name)
+	}
+	archives := loadTestArchives(t, t.TempDir(), testArchive{name: "d2data.mpq", Archive: mpqtest.Archive{Files: files}})
+	const panicPath = "data/global/excel/file07.txt"
+
+	readFile := archiveReadFile
+	t.Cleanup(func() { archiveReadFile = readFile })
+
+	golden := []struct {
+		jobs int
+		// Panic while reading the file, with the archive locked, rather than
+		// while writing it.
+		readPanic bool
+	}{
+		{jobs: 1},
+		{jobs: 4},
+		// More workers than files.
+		{jobs: 2 * fileCount},
+		{jobs: 1, readPanic: true},
+		{jobs: 4, readPanic: true},
+	}
+	for _, g := range golden {
+		t.Run(fmt.Sprintf("jobs=%d,readPanic=%v", g.jobs, g.readPanic), func(t *testing.T) {
+			sink := &memSink{files: make(map[string][]byte)}
+			archiveReadFile = readFile
+			if g.readPanic {
+				archiveReadFile = func(archive *d2mpq.MPQ, filePath string) ([]byte, error) {
+					if filePath == archivePath(panicPath) {
+						panic("injected panic")
+					}
+					return readFile(archive, filePath)
+				}
+			} else {
+				sink.panicPath = panicPath
+			}
+			opts := options{jobs: g.jobs, locks: newArchiveLocks(archives), diagnostics: &diagnostics{}}
+			type result struct {
+				summary extractSummary
+				err     error
+			}
+			done := make(chan result, 1)
+			go func() {
+				summary, err := extractAllFilesParallel([][]*d2mpq.MPQ{archives}, filePaths, sink, opts)
+				done <- result{summary: summary, err: err}
+			}()
+			var res result
+			select {
+			case res = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("extraction stalled after panic in worker")
+			}
+			if res.err != nil {
+				t.Fatalf("unexpected error; %+v", res.err)
+			}
+			if res.summary.extracted != fileCount-1 || res.summary.errors != 1 {
+				t.Errorf("summary mismatch; expected %d extracted and 1 error, got %v", fileCount-1, res.summary)
+			}
+			if len(sink.files) != fileCount-1 {
+				t.Errorf("extracted files mismatch; expected %d, got %d", fileCount-1, len(sink.files))
+			}
+			if _, ok := sink.files[panicPath]; ok {
+				t.Errorf("file %q of injected panic extracted", panicPath)
+			}
+			diags := opts.diagnostics.diags
+			if len(diags) != 1 || diags[0].Code != diagReadError || diags[0].Path != panicPath || !strings.Contains(diags[0].Message, "injected panic") {
+				t.Errorf("diagnostics mismatch; expected %s of %q reporting the panic, got %+v", diagReadError, panicPath, diags)
+			}
+		})
+	}
+}
+
+// TestExtractAllFilesParallelVerify checks the CRC32 of files extracted in
+// parallel, recorded in the (attributes) file preloaded before the workers
+// start.
+func TestExtractAllFilesParallelVerify(t *testing.T) {
+	const fileCount = 32
+	var files []mpqtest.File
+	var filePaths []string
+	for i := 0; i < fileCount; i++ {
+		name := fmt.Sprintf(`data\global\excel\file%02d.txt`, i)
+		files = append(files, mpqtest.File{Name: name, Data: append([]byte(name), books...), Compression: mpqtest.CompressionZlib})
+		filePaths = append(filePaths, name)
+	}
+	archives := loadTestArchives(t, t.TempDir(), testArchive{name: "d2data.mpq", Archive: mpqtest.Archive{Files: files, Attributes: true}})
+
+	golden := []struct {
+		jobs int
+	}{
+		{jobs: 1},
+		{jobs: 8},
+	}
+	for _, g := range golden {
+		t.Run(fmt.Sprintf("jobs=%d", g.jobs), func(t *testing.T) {
+			sink := &memSink{files: make(map[string][]byte)}
+			opts := options{jobs: g.jobs, locks: newArchiveLocks(archives), diagnostics: &diagnostics{}, verify: newCRCVerifier()}
+			summary, err := extractAllFilesParallel([][]*d2mpq.MPQ{archives}, filePaths, sink, opts)
+			if err != nil {
+				t.Fatalf("unexpected error; %+v", err)
+			}
+			if summary.extracted != fileCount || summary.errors != 0 {
+				t.Errorf("summary mismatch; expected %d extracted and no errors, got %v", fileCount, summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// writeJSONDump writes a JSON object mapping the normalized path of each file to
+// its base64-encoded contents to the given path. Files not present in any of the
+// MPQ archives are skipped.
+//
+// To avoid huge outputs, the total uncompressed size of the files is checked
+// against limit (in bytes) before anything is written; a limit of 0 disables the
+// check.
+func writeJSONDump(archives []*d2mpq.MPQ, filePaths []string, jsonPath string, limit int64, opts options) error {
+	var total int64
+	for _, filePath := range filePaths {
+		if size, ok := lookupFileSize(archives, filePath); ok {
+			total += int64(size)
+		}
+	}
+	if limit > 0 && total > limit {
+		return errors.Errorf("total size of files (%d bytes) exceeds JSON dump limit (%d bytes); see -json-dump-limit", total, limit)
+	}
+	f, err := os.Create(jsonPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if _, err := w.WriteString("{"); err != nil {
+		return errors.WithStack(err)
+	}
+	seen := make(map[string]bool)
+	for _, filePath := range filePaths {
+		data, _, err := readFile(archives, filePath, opts)
+		if err != nil {
+			if errors.Cause(err) == ErrNotFound {
+				log.Printf("file not found %q\n", filePath)
+				continue
+			}
+			return errors.WithStack(err)
+		}
+		dstPath := recordedPath(filePath, opts)
+		if seen[dstPath] {
+			continue
+		}
+		key, err := json.Marshal(dstPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if len(seen) > 0 {
+			if _, err := w.WriteString(",\n"); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		seen[dstPath] = true
+		fmt.Printf("encoding: %q\n", dstPath)
+		if _, err := fmt.Fprintf(w, "%s:\"", key); err != nil {
+			return errors.WithStack(err)
+		}
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		if _, err := enc.Write(data); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := enc.Close(); err != nil {
+			return errors.WithStack(err)
+		}
+		if _, err := w.WriteString("\""); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if _, err := w.WriteString("}\n"); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := w.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
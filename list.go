@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// listFiles prints the files of the embedded (listfile) of each MPQ archive
+// present in the archive, one per line prefixed by the output directory name of
+// the archive and a tab. If filePaths is non-empty, only the given files are
+// listed. No files are written to disk.
+func listFiles(archives []*d2mpq.MPQ, filePaths []string, opts options) error {
+	var filter map[string]bool
+	if len(filePaths) > 0 {
+		filter = make(map[string]bool)
+		for _, filePath := range filePaths {
+			if filePath = denormalize(filePath); len(filePath) > 0 {
+				filter[archivePath(filePath)] = true
+			}
+		}
+	}
+	for _, archive := range archives {
+		files, err := archive.GetFileList()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dir := archiveDir(archive, opts)
+		if opts.lower {
+			dir = strings.ToLower(dir)
+		}
+		for _, filePath := range files {
+			filePath = denormalize(filePath)
+			if len(filePath) == 0 || !archive.FileExists(filePath) {
+				continue
+			}
+			if filter != nil && !filter[archivePath(filePath)] {
+				continue
+			}
+			fmt.Printf("%s\t%s\n", dir, recordedPath(filePath, opts))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// includeDirective is the prefix of listfile lines referencing other listfiles.
+const includeDirective = "@include "
+
+// listfileEntry is an entry of a listfile.
+type listfileEntry struct {
+	// File path of entry.
+	filePath string
+	// Path to listfile containing the entry.
+	listfilePath string
+	// Line number of entry within the listfile.
+	line int
+}
+
+// listfileFlags is a command line flag of listfile paths, which may be
+// repeated or comma-separated.
+type listfileFlags []string
+
+// String returns the string representation of the listfile paths.
+func (listfiles *listfileFlags) String() string {
+	return strings.Join(*listfiles, ",")
+}
+
+// Set adds the comma-separated listfile paths.
+func (listfiles *listfileFlags) Set(s string) error {
+	for _, listfilePath := range strings.Split(s, ",") {
+		if listfilePath = strings.TrimSpace(listfilePath); len(listfilePath) > 0 {
+			*listfiles = append(*listfiles, listfilePath)
+		}
+	}
+	return nil
+}
+
+// readListfiles returns the concatenated entries of the given listfiles, as
+// read by readListfile, along with the number of listfiles merged.
+func readListfiles(listfilePaths []string, includes bool) ([]listfileEntry, int, error) {
+	var entries []listfileEntry
+	total := 0
+	for _, listfilePath := range listfilePaths {
+		es, n, err := readListfile(listfilePath, includes)
+		if err != nil {
+			return nil, 0, errors.WithStack(err)
+		}
+		entries = append(entries, es...)
+		total += n
+	}
+	return entries, total, nil
+}
+
+// readListfile returns the entries of the given listfile.
+//
+// When includes is set, lines of the form "@include otherlist.txt" are replaced
+// by the entries of the referenced listfile, which is located relative to the
+// directory of the including listfile. Included listfiles are merged
+// recursively, skipping duplicate entries; include cycles are reported as
+// errors. readListfile also returns the number of listfiles merged.
+func readListfile(listfilePath string, includes bool) ([]listfileEntry, int, error) {
+	r := &listfileReader{
+		includes: includes,
+		active:   make(map[string]bool),
+		seen:     make(map[string]bool),
+	}
+	if err := r.read(listfilePath); err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	return r.entries, r.nlistfiles, nil
+}
+
+// listfileReader reads listfiles, recursively merging included listfiles.
+type listfileReader struct {
+	// Merge included listfiles.
+	includes bool
+	// Absolute paths of listfiles currently being read; used for cycle
+	// detection.
+	active map[string]bool
+	// File paths of entries read so far; used to skip duplicate entries.
+	seen map[string]bool
+	// Entries read so far.
+	entries []listfileEntry
+	// Number of listfiles read.
+	nlistfiles int
+}
+
+// read reads the entries of the given listfile.
+func (r *listfileReader) read(listfilePath string) error {
+	absPath, err := filepath.Abs(listfilePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if r.active[absPath] {
+		return errors.Errorf("listfile include cycle at %q", listfilePath)
+	}
+	r.active[absPath] = true
+	defer delete(r.active, absPath)
+	buf, err := ioutil.ReadFile(listfilePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	r.nlistfiles++
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for line := 1; s.Scan(); line++ {
+		filePath := strings.TrimSpace(s.Text())
+		if len(filePath) == 0 {
+			continue
+		}
+		if r.includes && strings.HasPrefix(filePath, includeDirective) {
+			includePath := strings.TrimSpace(filePath[len(includeDirective):])
+			if !filepath.IsAbs(includePath) {
+				includePath = filepath.Join(filepath.Dir(listfilePath), includePath)
+			}
+			if err := r.read(includePath); err != nil {
+				return errors.Wrapf(err, "unable to include listfile on line %d of %q", line, listfilePath)
+			}
+			continue
+		}
+		if r.includes {
+			if r.seen[filePath] {
+				continue
+			}
+			r.seen[filePath] = true
+		}
+		entry := listfileEntry{
+			filePath:     filePath,
+			listfilePath: listfilePath,
+			line:         line,
+		}
+		r.entries = append(r.entries, entry)
+	}
+	if err := s.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// locate prints one line for every MPQ archive containing the given file (in
+// search order), with the locale, platform and flags of the hash and block
+// table entries to which the file resolves, as well as the locales of all hash
+// table entries of the file in the MPQ archive.
+func locate(archives []*d2mpq.MPQ, filePath string) error {
+	if len(filePath) == 0 {
+		return errors.New("empty file path")
+	}
+	key := archivePath(denormalize(filePath))
+	_, hashA, hashB := d2mpq.HashFileName(key)
+	found := 0
+	for _, archive := range archives {
+		entry, ok := archive.FileHashEntry(key)
+		if !ok || entry.BlockIndex >= uint32(len(archive.BlockTableEntries)) {
+			continue
+		}
+		found++
+		block := archive.BlockTableEntries[entry.BlockIndex]
+		var locales []string
+		for _, e := range archive.HashTableEntries {
+			if e.NamePartA == hashA && e.NamePartB == hashB && e.BlockIndex != d2mpq.BlockIndexFree && e.BlockIndex != d2mpq.BlockIndexDeleted {
+				locales = append(locales, fmt.Sprintf("0x%04X", e.Locale))
+			}
+		}
+		fmt.Printf("%s\tlocale 0x%04X\tplatform 0x%04X\tflags 0x%08X\tsize %d\tlocales %s\n", archive.FileName, entry.Locale, entry.Platform, uint32(block.Flags), block.UncompressedFileSize, strings.Join(locales, ","))
+	}
+	if found == 0 {
+		return errors.Wrapf(ErrNotFound, "file not found %q", filePath)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// quiet suppresses informational output (e.g. the per-file "extracting" and
+// "creating" lines); warnings and summaries are still printed.
+var quiet bool
+
+// infoOut is the destination of informational output; standard error when file
+// contents are written to standard output (see -stdout).
+var infoOut io.Writer = os.Stdout
+
+// infof prints informational output to infoOut, unless quiet is set.
+func infof(format string, args ...interface{}) {
+	if quiet {
+		return
+	}
+	fmt.Fprintf(infoOut, format, args...)
+}
@@ -2,16 +2,16 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/OpenDiablo2/OpenDiablo2/d2data/d2mpq"
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
 	"github.com/mewkiz/pkg/pathutil"
 	"github.com/pkg/errors"
 )
@@ -20,7 +20,10 @@ const use = `
 Extracts files from MPQ archives.
 
 Usage:
-	MpqViewer [OPTION]... [FILE.mpq]...
+	MpqViewer [OPTION]... [[LABEL=]FILE.mpq]...
+
+An optional LABEL names the output directory of the MPQ archive, which is
+otherwise named after the base name of FILE.mpq.
 
 Example (extract all files specified in the bundled "Diablo II LOD.txt" listfile):
 	MpqViewer -a -mpq_dir /path/to/diablo_ii
@@ -34,6 +37,39 @@ Example (extract all files specified in the embedded (listfile) of each MPQ arch
 Example (extract specific files from d2data.mpq):
 	MpqViewer -files "/data/global/excel/books.txt,/data/global/excel/charstats.txt" /path/to/d2data.mpq
 
+Example (extract all files from the MPQ archives configured in OpenDiablo2's config.json):
+	MpqViewer -a -od2-config /path/to/OpenDiablo2/config.json
+
+Example (extract d2data.mpq of two Diablo II installs side by side into _dump_/v109 and _dump_/v114):
+	MpqViewer -a -embedded v109=/path/to/d2_109/d2data.mpq v114=/path/to/d2_114/d2data.mpq
+
+Example (extract all files into table files(path TEXT, archive TEXT, size INTEGER, data BLOB) of a SQLite database):
+	MpqViewer -a -sqlite d2.db -mpq_dir /path/to/diablo_ii
+
+Example (serve files over HTTP, e.g. http://localhost:8080/data/global/excel/books.txt):
+	MpqViewer -serve :8080 -mpq_dir /path/to/diablo_ii
+
+Example (convert WAV files to MP3 using ffmpeg while extracting):
+	MpqViewer -files "/data/global/music/intro.wav" -pipe "ffmpeg -i - -f mp3 -" -pipe-ext .mp3 /path/to/d2music.mpq
+
+Example (mount all files as a read-only FUSE file system; Linux only):
+	MpqViewer -a -mount /mnt/d2 -mpq_dir /path/to/diablo_ii
+
+Example (write a tab-separated index of the path and size of all files, without extracting):
+	MpqViewer -a -tsv index.tsv -mpq_dir /path/to/diablo_ii
+
+Example (download remote MPQ archive, resuming any interrupted download, and extract all files):
+	MpqViewer -a -url https://example.com/d2data.mpq -resume
+
+Example (restart an interrupted extraction of all files, skipping files already extracted):
+	MpqViewer -a -resume-extract -mpq_dir /path/to/diablo_ii
+
+Example (use default flags of JSON config file, e.g. {"a": true, "mpq_dir": "/path/to/diablo_ii"}):
+	MpqViewer -config d2.json -lower
+
+Flags of the config file are applied as defaults; flags specified on the
+command line take precedence over those of the config file.
+
 Flags:
 `
 
@@ -51,73 +87,564 @@ func main() {
 		embedded bool
 		// Comma-separated list of files to extract.
 		rawFilePaths string
-		// Path to listfile.txt
-		listfilePath string
-		// Use lowercase for output file paths.
-		lower bool
+		// Paths to listfiles.
+		listfilePaths listfileFlags
+		// Extraction options.
+		opts options
 		// Path to Diablo II MPQ directory.
 		mpqDir string
+		// Load all MPQ archives below mpqDir.
+		recursive bool
+		// URL of remote MPQ archive to download and extract.
+		mpqURL string
+		// Resume interrupted download of remote MPQ archive.
+		resumeDownload bool
+		// Number of times to retry failed range requests.
+		retries int
+		// Path to tab-separated index of file paths and sizes.
+		tsvPath string
+		// Path to CSV index of file sizes and compression.
+		csvPath string
+		// Disable fallback to the default Diablo II MPQ archives.
+		noDefaultArchives bool
+		// Path to SQLite database to extract files into.
+		sqlitePath string
+		// List block table entries not referenced by the hash table.
+		listOrphansMode bool
+		// Report number of files not named by listfile.
+		unnamedMode bool
+		// Print header fields of MPQ archives.
+		infoMode bool
+		// Path to wordlist of candidate names of unnamed files.
+		wordlistPath string
+		// Address to serve files over HTTP on.
+		serveAddr string
+		// Maximum number of simultaneous file reads in serve mode.
+		serveMaxConcurrency int
+		// Timeout of HTTP requests in serve mode.
+		serveTimeout time.Duration
+		// Directory to mount MPQ archives at as a FUSE file system.
+		mountDir string
+		// Browse files interactively.
+		interactive bool
+		// Path to OpenDiablo2 configuration file.
+		od2ConfigPath string
+		// Priorities of MPQ archives.
+		priorities = make(priorityFlags)
+		// Paths of patch MPQ archives.
+		patchPaths patchFlags
+		// Report malformed listfile entries.
+		strictPaths bool
+		// Verify the CRC32 of extracted files.
+		verifyCRC bool
+		// Preserve the modification time of extracted files.
+		preserveTimes bool
+		// Merge listfiles referenced by "@include" directives.
+		listfileIncludes bool
+		// Print tally of files per extension.
+		extReport bool
+		// Extract only the (listfile) of each MPQ archive.
+		listfileOnly bool
+		// Output directory.
+		outDir string
+		// Extract into staging directory, replacing the output directory on
+		// success.
+		staging bool
+		// Remove staging directory on failure.
+		cleanStaging bool
+		// Detect sector size of MPQ archives with invalid sector size.
+		detectSectors bool
+		// Loose asset name of files to extract.
+		assetName string
+		// Minimum similarity score of files matching asset name.
+		assetThreshold float64
+		// Compare two MPQ archives.
+		compareMode bool
+		// Maximum total size in bytes of LRU cache of decompressed files.
+		cacheSize int64
+		// Maximum total size in MiB of LRU cache of decompressed sectors, per
+		// MPQ archive.
+		sectorCacheMB int64
+		// Fail if any file of -files is not found.
+		requireAll bool
+		// Print patch-delta files of patch archives.
+		dumpPatchMeta bool
+		// Existing output directory to normalize.
+		normalizeDir string
+		// Package name of Go source embedding files.
+		goPkg string
+		// Path to Go source embedding files.
+		goPath string
+		// Maximum total size in bytes of files in Go source.
+		goLimit int64
+		// File path of which to trace resolution.
+		explainPath string
+		// File name of which to print MPQ hashes.
+		hashName string
+		// File path of which to list containing MPQ archives.
+		locatePath string
+		// Apply un-protection heuristics to MPQ archives.
+		protected bool
+		// Path to log file.
+		logPath string
+		// Number of files to randomly sample.
+		sampleSize int
+		// Seed of random sampling.
+		seed int64
+		// Check health of MPQ archives.
+		healthMode bool
+		// List files of MPQ archives.
+		listMode bool
+		// Search file contents of MPQ archives for pattern.
+		grepPattern string
+		// Match -grep pattern case-insensitively.
+		grepIgnoreCase bool
+		// File extensions searched by -grep.
+		rawGrepExts string
+		// Extract only files of external listfile missing from embedded
+		// (listfile).
+		deltaOnly bool
+		// Path to JSON diagnostics file of warnings emitted during extraction.
+		diagPath string
+		// Path to JSON manifest of extracted files.
+		manifestPath string
+		// Comma-separated list of glob patterns of files to skip.
+		rawExcludes string
+		// Comma-separated list of file types to split into sub-elements.
+		rawSplitTypes string
+		// Path to JSON config file of default flags.
+		configPath string
+		// Extract only files modified at or after the given time.
+		rawSince string
+		// Extract only files of at least and at most the given uncompressed
+		// size.
+		rawMinSize, rawMaxSize string
+		// Create empty placeholder files instead of extracting.
+		touchOnly bool
+		// Write contents of single file to standard output.
+		toStdout bool
+		// Path to base64 JSON dump of files.
+		jsonDumpPath string
+		// Maximum total size in bytes of files in JSON dump.
+		jsonDumpLimit int64
+		// Number of slowest files to read to report.
+		slowest int
+		// Report decompression throughput without writing files.
+		bench bool
 	)
 	flag.BoolVar(&all, "a", false, "extract all files")
-	flag.BoolVar(&embedded, "embedded", false, "use embedded (listfile) to locate files in MPQ archives")
-	flag.StringVar(&rawFilePaths, "files", "", "comma-separated list of files to extract")
-	flag.StringVar(&listfilePath, "l", "", "path to listfile")
-	flag.BoolVar(&lower, "lower", false, "use lowercase for output file paths")
+	flag.BoolVar(&extReport, "ext-report", false, "print number of files and total size per file extension (instead of extracting)")
+	flag.BoolVar(&embedded, "embedded", false, "use embedded (listfile) to locate files in MPQ archives; with -l, the file paths of both are combined")
+	flag.StringVar(&rawFilePaths, "files", "", "comma-separated list of files to extract; entries containing '*', '?' or '[' are glob patterns matched against the embedded (listfile) of each MPQ archive")
+	flag.BoolVar(&listfileOnly, "extract-listfile-only", false, "extract only the embedded (listfile) of each MPQ archive")
+	flag.Var(&listfilePaths, "l", "path to listfile; may be repeated or comma-separated to merge listfiles, skipping duplicate file paths")
+	flag.BoolVar(&listfileIncludes, "listfile-includes", false, `merge listfiles referenced by "@include otherlist.txt" lines of the listfile (relative to the including listfile)`)
+	flag.BoolVar(&opts.lower, "lower", false, "use lowercase for output file paths")
+	flag.BoolVar(&opts.showOffsets, "show-offsets", false, "log the byte offset within the MPQ archive of each extracted file")
+	flag.StringVar(&opts.pipeCmd, "pipe", "", "pipe the contents of each file through shell command (file path in $MPQ_FILE), writing its output instead")
+	flag.StringVar(&opts.pipeExt, "pipe-ext", "", "extension of output files when using -pipe (e.g. .mp3)")
+	flag.Var(&opts.rewrites, "rewrite", "rewrite output path prefix, as from=to (e.g. data/global=assets); may be repeated, applied in order")
+	flag.BoolVar(&opts.localeFallback, "locale-fallback", false, "retry language-neutral file when localized file fails to read")
+	flag.StringVar(&wordlistPath, "bruteforce", "", "path to wordlist of candidate file names (one per line); candidates matching the name hashes of files not named by the listfile are added to the extracted files")
+	flag.BoolVar(&unnamedMode, "orphans", false, "report the number of files of each MPQ archive not named by the listfile (see -l and -embedded), to judge listfile completeness (instead of extracting)")
+	flag.BoolVar(&infoMode, "info", false, "print the header fields of each MPQ archive (format version, archive size, sector size, and hash and block table offsets and sizes) (instead of extracting)")
+	flag.BoolVar(&listOrphansMode, "list-orphans", false, "list block table entries not referenced by any hash table entry (instead of extracting)")
+	flag.BoolVar(&interactive, "interactive", false, "browse the directory tree of files in a terminal, previewing the selected file and extracting it to -out on request (instead of extracting)")
+	flag.StringVar(&mountDir, "mount", "", "mount files as a read-only FUSE file system at directory (instead of extracting)")
 	flag.StringVar(&mpqDir, "mpq_dir", ".", "path to Diablo II MPQ directory")
+	flag.BoolVar(&recursive, "recursive", false, "load every *.mpq file below -mpq_dir (in sorted order) instead of the default Diablo II MPQ archives")
+	flag.StringVar(&mpqURL, "url", "", "URL of remote MPQ archive to download and extract; the entire MPQ archive is downloaded into mpq_dir before extraction, as files are not read directly from the remote")
+	flag.BoolVar(&resumeDownload, "resume", false, "resume interrupted download of remote MPQ archive (see -url); to resume an interrupted extraction, use -resume-extract")
+	flag.IntVar(&retries, "retries", 3, "number of times to retry failed range requests")
+	flag.BoolVar(&noDefaultArchives, "no-default-archives", false, "only use the MPQ archives specified on the command line; never fall back to the default Diablo II MPQ archives of mpq_dir")
+	flag.StringVar(&od2ConfigPath, "od2-config", "", "path to OpenDiablo2 config.json from which to read the MPQ directory and load order")
+	flag.Var(&patchPaths, "patch", "load patch MPQ archive, as [LABEL=]FILE.mpq, which overrides the files of all other MPQ archives (later patches override earlier ones); Patch_D2.mpq of -mpq_dir is loaded as patch by default; may be repeated")
+	flag.Var(priorities, "priority", "assign search priority to MPQ archive, as NAME=N where NAME is the label or base name of the archive; higher priority archives are searched first, and archives of equal priority by name (default priority is based on load order, first highest); may be repeated")
+	flag.StringVar(&serveAddr, "serve", "", "serve files over HTTP on address (e.g. \":8080\") instead of extracting")
+	flag.IntVar(&serveMaxConcurrency, "serve-max-concurrency", 4, "maximum number of simultaneous file reads in serve mode")
+	flag.DurationVar(&serveTimeout, "serve-timeout", 30*time.Second, "timeout of HTTP requests in serve mode")
+	flag.BoolVar(&strictPaths, "strict-paths", false, "report and skip malformed listfile entries (absolute paths, drive letters, embedded NUL characters)")
+	flag.BoolVar(&detectSectors, "detect-sector-size", false, "detect the sector size of MPQ archives with a zeroed or invalid sector size field, by probing sector offset tables")
+	flag.StringVar(&assetName, "asset", "", `extract the file(s) best matching the given loose asset name (e.g. "charstats"), located using the listfile`)
+	flag.Float64Var(&assetThreshold, "asset-threshold", 0.6, "minimum similarity score in [0, 1] of files matching -asset")
+	flag.BoolVar(&compareMode, "compare-archives", false, "report files only in either of two MPQ archives, and whether the contents of shared files differ (requires exactly two MPQ archives and an embedded (listfile))")
+	flag.Int64Var(&sectorCacheMB, "cache-mb", 0, "cache decompressed sectors of each MPQ archive in an LRU cache of at most the given size in MiB, to avoid decompressing the same sector repeatedly (default: no sector cache)")
+	flag.Int64Var(&cacheSize, "cache-size", 0, "cache decompressed files in an LRU cache of at most the given total size in bytes, to speed up repeated reads of the same file (default: cache every file read, without bound)")
+	flag.BoolVar(&requireAll, "require-all-files", false, "exit with an error listing the files of -files not found in any of the MPQ archives")
+	flag.BoolVar(&dumpPatchMeta, "dump-patch-metadata", false, "print the patch-delta files of each MPQ archive, as listed by its (patch_metadata) file, along with the archive containing their base files (instead of extracting)")
+	flag.StringVar(&normalizeDir, "normalize-output", "", "rename the files and directories of an existing output directory to lowercase, forward-slash form, merging identical duplicates and reporting collisions (no MPQ archives are read)")
+	flag.StringVar(&goPkg, "gen-go", "", "write Go source declaring a map from normalized file path to contents of each file, within the given package (instead of extracting)")
+	flag.StringVar(&goPath, "gen-go-out", "", `path to Go source written by -gen-go (default "PACKAGE.go")`)
+	flag.Int64Var(&goLimit, "gen-go-limit", 1<<20, "maximum total size in bytes of files written by -gen-go (0 for no limit)")
+	flag.StringVar(&explainPath, "explain", "", "trace how the given file path resolves; print each MPQ archive checked, the locale, platform and flags of matching entries, and the chosen MPQ archive (instead of extracting)")
+	flag.StringVar(&hashName, "hash-name", "", "print the MPQ hashes (in hex) of the given file name, and its hash table index in each MPQ archive specified (instead of extracting)")
+	flag.StringVar(&locatePath, "locate", "", "list every MPQ archive containing the given file path, along with its locale and flags (instead of extracting)")
+	flag.BoolVar(&protected, "protected", false, "apply un-protection heuristics to MPQ archives altered to thwart MPQ tools; ignore declared header size and unknown format versions, clamp table sizes to the file size, and drop fake block table entries")
+	flag.StringVar(&logPath, "log-file", "", "append log output (warnings and errors, with timestamps) to the given file, in addition to stderr")
+	flag.IntVar(&sampleSize, "sample", 0, "extract only n files selected at random from the file paths (e.g. of the listfile), for spot-checking")
+	flag.Int64Var(&seed, "seed", 0, "seed of -sample, for reproducible sampling (default: random seed, which is reported)")
+	flag.BoolVar(&healthMode, "healthcheck", false, "verify that each MPQ archive opens and has a readable (listfile); print a one-line status and exit with status 0 if healthy and 1 otherwise (instead of extracting)")
+	flag.IntVar(&opts.jobs, "jobs", 1, "number of files to extract in parallel; reads from each MPQ archive are serialized, and errors are reported at the end rather than aborting")
+	flag.BoolVar(&opts.continueOnError, "continue-on-error", false, "log, count and skip every file which failed to extract (e.g. due to a full disk) rather than aborting, and exit with a non-zero status at the end if any error occurred")
+	flag.StringVar(&grepPattern, "grep", "", "print the files of the embedded (listfile) of each MPQ archive whose contents contain the given substring, prefixed by archive name (instead of extracting)")
+	flag.BoolVar(&grepIgnoreCase, "i", false, "with -grep, match case-insensitively")
+	flag.StringVar(&rawGrepExts, "grep-ext", "", "with -grep, comma-separated list of file extensions to search (e.g. \".txt,.tbl\"); other files are not decompressed")
+	flag.BoolVar(&listMode, "list", false, "print the files of the embedded (listfile) of each MPQ archive, prefixed by archive name (instead of extracting); honours -lower and -files")
+	flag.BoolVar(&toStdout, "stdout", false, "write the decompressed contents of the single file of -files to standard output (instead of extracting); informational output is written to standard error")
+	flag.BoolVar(&touchOnly, "touch-only", false, "create zero-byte placeholder files at the output path of each file (instead of extracting)")
+	flag.StringVar(&jsonDumpPath, "json-dump", "", "write JSON object mapping each normalized file path to its base64-encoded contents to the given path")
+	flag.Int64Var(&jsonDumpLimit, "json-dump-limit", 16<<20, "maximum total size in bytes of files written by -json-dump (0 for no limit)")
+	flag.StringVar(&opts.typeFilter, "type", "", "extract only files whose contents are detected as the given file type, regardless of extension ("+fileTypesHelp()+")")
+	flag.Var(&opts.pathStyle, "path-style", "path separator style of file paths recorded in indices, dumps, listings and databases (unix or windows); files on disk always use the host separator")
+	flag.BoolVar(&opts.sha256Sidecar, "with-sha256-sidecar", false, `write a "<file>.sha256" sidecar file containing the SHA-256 hash next to each extracted file, for later verification using "sha256sum -c" (doubles the number of output files; not supported when storing files in a database or archive)`)
+	flag.StringVar(&diagPath, "diagnostics", "", "write the warnings emitted during extraction (code, severity, path and message) as a JSON array to the given path")
+	flag.BoolVar(&deltaOnly, "listfile-delta", false, "with -a and -l, extract only the files of the external listfile missing from the embedded (listfile) of each MPQ archive")
+	flag.StringVar(&manifestPath, "manifest", "", "write a JSON manifest of extracted files (MPQ path, archive, destination path, size, offset and whether the file was found) to the given path")
+	flag.StringVar(&rawExcludes, "exclude", "", "comma-separated list of glob patterns of files to skip (e.g. \"*.bik,data/global/music/*\"); patterns without a slash match the base name")
+	flag.StringVar(&rawSplitTypes, "split-frames", "", fmt.Sprintf("comma-separated list of file types of which each frame is extracted as a separately numbered file (e.g. foo.000.dc6); supported types: %s", splitTypes()))
+	flag.StringVar(&configPath, "config", "", "path to JSON config file of default flags, keyed by flag name (overridden by command line flags)")
+	flag.BoolVar(&opts.strict, "strict", false, "fail when the size of an extracted file differs from the uncompressed size of its block table entry (instead of logging a warning)")
+	flag.BoolVar(&verifyCRC, "verify", false, "verify the CRC32 of each extracted file against the (attributes) file of its MPQ archive, and fail on mismatch")
+	flag.BoolVar(&preserveTimes, "preserve-times", false, "set the modification time of each extracted file to the time recorded in the (attributes) file of its MPQ archive; files without a recorded time keep the time of extraction, and output directories get the latest time of the files they contain")
+	flag.StringVar(&rawMinSize, "min-size", "", "extract only files whose uncompressed size is at least the given size in bytes, optionally suffixed by K, M or G (e.g. \"500K\")")
+	flag.StringVar(&rawMaxSize, "max-size", "", "extract only files whose uncompressed size is at most the given size in bytes, optionally suffixed by K, M or G (e.g. \"1M\")")
+	flag.StringVar(&rawSince, "since", "", "extract only files modified at or after the given date (YYYY-MM-DD) or RFC 3339 time, as recorded in the (attributes) file of MPQ archives; files of MPQ archives without (attributes) are kept")
+	flag.BoolVar(&quiet, "quiet", false, "suppress informational output (e.g. per-file extracting and creating lines); warnings and summaries are still printed")
+	flag.BoolVar(&opts.flat, "flat", false, "omit the per-archive subdirectory of output paths, so that the output mirrors the virtual file system of the game")
+	flag.BoolVar(&opts.flatOverwrite, "flat-overwrite", false, "with -flat, overwrite files extracted from earlier MPQ archives with those of later MPQ archives (instead of skipping them); existing files of earlier extractions are still skipped with -no-clobber")
+	flag.Var(policyFlag{policy: &opts.existing, value: existingOverwrite, negated: existingNoClobber}, "overwrite", "overwrite files whose output path already exists; of -overwrite, -no-clobber and -resume-extract, the flag given last takes precedence")
+	flag.Var(policyFlag{policy: &opts.existing, value: existingNoClobber, negated: existingOverwrite}, "no-clobber", "skip files whose output path already exists (e.g. hand-edited files of an earlier extraction) instead of overwriting them, without decompressing them; of -overwrite, -no-clobber and -resume-extract, the flag given last takes precedence")
+	flag.Var(policyFlag{policy: &opts.existing, value: existingResume, negated: existingOverwrite}, "resume-extract", "resume interrupted extraction by skipping files whose output file already has the uncompressed size recorded in the MPQ archive, and overwriting missing or differently sized files; of -overwrite, -no-clobber and -resume-extract, the flag given last takes precedence")
+	flag.BoolVar(&opts.force, "force", false, "make read-only destination files writable before overwriting them")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "report the output path of each file without writing any files")
+	flag.StringVar(&outDir, "out", "_dump_", "output directory of extracted files (relative or absolute)")
+	flag.BoolVar(&staging, "staging", false, "extract into a staging directory which replaces the output directory only once all files have been extracted")
+	flag.BoolVar(&cleanStaging, "clean-staging", false, "remove the staging directory if extraction fails (default: leave it for inspection)")
+	flag.StringVar(&sqlitePath, "sqlite", "", "extract files into SQLite database at path (instead of the output directory)")
+	flag.StringVar(&opts.tarPath, "tar", "", "extract files into tar archive at path, named by archive and file path (or file path only with -flat) (instead of the output directory)")
+	flag.StringVar(&opts.zipPath, "zip", "", "extract files into zip archive at path, named by archive and file path (or file path only with -flat); already compressed files (e.g. .bik) are stored, others deflated (instead of the output directory)")
+	flag.BoolVar(&bench, "bench", false, "read and decompress the files without writing them, and report the decompression throughput in total and per MPQ archive (instead of extracting)")
+	flag.IntVar(&slowest, "timings", 0, "record the read duration of each file and report the n slowest files")
+	flag.StringVar(&csvPath, "index", "", "write CSV index of archive, file path, size, compressed size, compression ratio, encryption and offset of each file to path (instead of extracting)")
+	flag.StringVar(&tsvPath, "tsv", "", "write tab-separated index of file paths and sizes to path (instead of extracting)")
+	if path := configFlag(os.Args[1:]); len(path) > 0 {
+		if err := loadConfig(path); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
 	flag.Parse()
 
+	// Tee log output to file.
+	if len(logPath) > 0 {
+		f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("%+v", errors.WithStack(err))
+		}
+		defer f.Close()
+		log.SetOutput(io.MultiWriter(os.Stderr, f))
+		log.Printf("logging to %q; %s\n", logPath, strings.Join(os.Args, " "))
+	}
+
+	if len(outDir) == 0 {
+		log.Fatalf("empty output directory of -out")
+	}
+	outDir = filepath.Clean(outDir)
+
+	// Record warnings as diagnostics.
+	if len(diagPath) > 0 {
+		opts.diagnostics = &diagnostics{}
+	}
+	if verifyCRC {
+		opts.verify = newCRCVerifier()
+	}
+	if toStdout {
+		infoOut = os.Stderr
+	}
+	if preserveTimes {
+		opts.modTimes = newTimePreserver()
+	}
+
+	// Normalize existing output directory.
+	if len(normalizeDir) > 0 {
+		if err := normalizeOutput(normalizeDir); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Print MPQ hashes of file name.
+	if len(hashName) > 0 {
+		d2mpq.InitializeCryptoBuffer()
+		if err := printNameHash(hashName, flag.Args(), protected); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
 	// Get MPQ paths.
 	mpqPaths := flag.Args()
-	if len(mpqPaths) == 0 {
-		mpqNames := []string{"d2char.mpq", "d2video.mpq", "d2data.mpq", "d2xmusic.mpq", "d2exp.mpq", "d2xtalk.mpq", "d2music.mpq", "d2xvideo.mpq", "d2sfx.mpq", "d2speech.mpq"} //, "Patch_D2.mpq"}
-		for _, mpqName := range mpqNames {
-			mpqPath := filepath.Join(mpqDir, mpqName)
-			mpqPaths = append(mpqPaths, mpqPath)
+	var downloaded int64
+	if len(mpqURL) > 0 {
+		infof("downloading %q\n", mpqURL)
+		mpqPath, n, err := downloadArchive(mpqURL, mpqDir, resumeDownload, retries)
+		downloaded = n
+		if err != nil {
+			log.Fatalf("%+v", err)
 		}
+		mpqPaths = append(mpqPaths, mpqPath)
+	}
+	if len(mpqPaths) == 0 && len(patchPaths) == 0 && noDefaultArchives {
+		log.Fatalf("no MPQ archives specified; specify FILE.mpq, -patch or -url when using -no-default-archives")
+	}
+	if len(mpqPaths) == 0 && !noDefaultArchives {
+		mpqNames := []string{"d2char.mpq", "d2video.mpq", "d2data.mpq", "d2xmusic.mpq", "d2exp.mpq", "d2xtalk.mpq", "d2music.mpq", "d2xvideo.mpq", "d2sfx.mpq", "d2speech.mpq"}
+		if len(od2ConfigPath) > 0 {
+			// Locate MPQ archives using the OpenDiablo2 configuration file;
+			// -mpq_dir takes precedence if specified.
+			config, err := loadOD2Config(od2ConfigPath)
+			switch {
+			case err != nil && os.IsNotExist(errors.Cause(err)):
+				log.Printf("OpenDiablo2 config %q not found; using default MPQ archives\n", od2ConfigPath)
+			case err != nil:
+				log.Fatalf("%+v", err)
+			default:
+				if len(config.MpqPath) > 0 && !isFlagSet("mpq_dir") {
+					mpqDir = config.MpqPath
+				}
+				if len(config.MpqLoadOrder) > 0 {
+					mpqNames = config.MpqLoadOrder
+				}
+			}
+		}
+		if recursive {
+			paths, err := findArchives(mpqDir)
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			if len(paths) == 0 {
+				log.Fatalf("no MPQ archives found in %q", mpqDir)
+			}
+			infof("found %d MPQ archive(s) in %q\n", len(paths), mpqDir)
+			mpqPaths = paths
+		} else {
+			for _, mpqName := range mpqNames {
+				mpqPath := filepath.Join(mpqDir, mpqName)
+				mpqPaths = append(mpqPaths, mpqPath)
+			}
+			// Load Diablo II patch archive, if present.
+			patchPath := filepath.Join(mpqDir, "Patch_D2.mpq")
+			if _, err := os.Stat(patchPath); err == nil {
+				patchPaths = append(patchFlags{patchPath}, patchPaths...)
+			}
+		}
+	}
+	isPatch := make(map[string]bool)
+	for _, patchPath := range patchPaths {
+		isPatch[patchPath] = true
+		mpqPaths = append(mpqPaths, patchPath)
 	}
 
 	// Initialize MPQ hash table.
 	d2mpq.InitializeCryptoBuffer()
 
+	// Check health of MPQ archives.
+	if healthMode {
+		n, err := healthcheck(mpqPaths, protected)
+		fmt.Println(healthStatus(len(mpqPaths), n, err))
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Open MPQ archives.
 	var archives []*d2mpq.MPQ
-	for _, mpqPath := range mpqPaths {
-		archive, err := d2mpq.Load(mpqPath)
+	opts.labels = make(map[*d2mpq.MPQ]string)
+	opts.patches = make(map[*d2mpq.MPQ]bool)
+	for _, mpqArg := range mpqPaths {
+		label, mpqPath := parseArchiveArg(mpqArg)
+		archive, err := loadArchive(mpqPath, protected)
 		if err != nil {
-			log.Fatalf("%+v", errors.WithStack(err))
+			log.Fatalf("%+v", err)
+		}
+		if isPatch[mpqArg] {
+			opts.patches[archive] = true
+		}
+		if detectSectors {
+			if shift, ok := detectSectorSize(archive); ok && shift != archive.Data.BlockSize {
+				log.Printf("invalid sector size shift %d of %q; using detected sector size of %d bytes\n", archive.Data.BlockSize, mpqPath, 0x200<<shift)
+				archive.Data.BlockSize = shift
+			}
+		}
+		if len(label) > 0 {
+			opts.labels[archive] = label
 		}
 		archives = append(archives, archive)
 	}
 
+	// Compare file sets and contents of two MPQ archives.
+	if compareMode {
+		if len(archives) != 2 {
+			log.Fatalf("-compare-archives requires exactly two MPQ archives; got %d", len(archives))
+		}
+		diff, err := compareArchives(archives[0], archives[1])
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		printArchiveDiff(archives[0], archives[1], diff, opts)
+		return
+	}
+
+	archivePriorities := sortArchives(archives, priorities, opts)
+
+	// Replace the unbounded per-archive file cache with a bounded LRU cache.
+	if cacheSize > 0 {
+		opts.cache = newReadCache(cacheSize)
+		for _, archive := range archives {
+			archive.DisableFileCache()
+		}
+	}
+
+	// Cache decompressed sectors of each MPQ archive.
+	if sectorCacheMB > 0 {
+		for _, archive := range archives {
+			archive.SetSectorCacheSize(sectorCacheMB << 20)
+		}
+	}
+
+	// Trace resolution of file.
+	if len(explainPath) > 0 {
+		if err := explain(archives, explainPath, archivePriorities, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// List MPQ archives containing file.
+	if len(locatePath) > 0 {
+		if err := locate(archives, locatePath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Print patch-delta files of patch archives.
+	if dumpPatchMeta {
+		if err := dumpPatchMetadata(archives, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Print header fields of MPQ archives.
+	if infoMode {
+		printInfo(archives)
+		return
+	}
+
+	// List orphaned block table entries.
+	if listOrphansMode {
+		listOrphans(archives)
+		return
+	}
+
+	// Extract embedded (listfile) of each MPQ archive.
+	if listfileOnly {
+		sink, err := newSink(outDir, sqlitePath, opts)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		if err := extractListfiles(archives, sink, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		if err := sink.Close(); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Serve files over HTTP.
+	if len(serveAddr) > 0 {
+		if err := serve(serveAddr, archives, serveMaxConcurrency, serveTimeout, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Search file contents of each MPQ archive.
+	if len(grepPattern) > 0 {
+		if err := grepFiles(archives, grepPattern, grepIgnoreCase, parseGrepExts(rawGrepExts), opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// List files of embedded (listfile) of each MPQ archive.
+	if listMode {
+		var filter []string
+		if len(rawFilePaths) > 0 {
+			filter = strings.Split(rawFilePaths, ",")
+		}
+		if err := listFiles(archives, filter, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
 	// Get file paths to extract.
 	var filePaths []string
 	if len(rawFilePaths) > 0 {
-		filePaths = strings.Split(rawFilePaths, ",")
+		files, err := expandGlobs(archives, strings.Split(rawFilePaths, ","))
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		filePaths = files
 	}
-	if len(filePaths) == 0 {
-		if !all {
-			log.Fatalf("no files to extract specified; specify either FILE or -a")
+	if deltaOnly && len(listfilePaths) == 0 {
+		log.Fatalf("-listfile-delta requires an external listfile; specify -l")
+	}
+	if deltaOnly && embedded {
+		log.Fatalf("-listfile-delta cannot be combined with -embedded")
+	}
+	if len(rawFilePaths) == 0 {
+		if !all && len(assetName) == 0 {
+			log.Fatalf("no files to extract specified; specify either FILE, -a or -asset")
 		}
 		if embedded {
-			fmt.Println("getting file paths from embedded (listfile)")
+			infof("getting file paths from embedded (listfile)\n")
 			files, err := getFilePathsFromEmbeddedListfile(archives)
 			if err != nil {
 				log.Fatalf("%+v", err)
 			}
 			filePaths = files
-		} else if len(listfilePath) > 0 {
-			fmt.Printf("getting file paths from listfile %q\n", listfilePath)
-			files, err := getFilePathsFromListfile(archives, listfilePath)
+		}
+		if len(listfilePaths) > 0 {
+			infof("getting file paths from listfile %q\n", listfilePaths.String())
+			files, err := getFilePathsFromListfile(archives, listfilePaths, strictPaths, listfileIncludes)
 			if err != nil {
 				log.Fatalf("%+v", err)
 			}
-			filePaths = files
-		} else {
+			if deltaOnly {
+				files, err = listfileDelta(archives, files)
+				if err != nil {
+					log.Fatalf("%+v", err)
+				}
+				infof("found %d file(s) in listfile %q missing from embedded (listfile)\n", len(files), listfilePaths.String())
+			}
+			if embedded {
+				n := len(filePaths)
+				filePaths = unionFilePaths(filePaths, files)
+				infof("added %d file(s) of listfile %q missing from embedded (listfile)\n", len(filePaths)-n, listfilePaths.String())
+			} else {
+				filePaths = files
+			}
+		}
+		if !embedded && len(listfilePaths) == 0 {
 			// Use bundled "Diablo II LOD.txt" listfile of Zezula's MPQ Editor.
 			//
 			// ref: http://www.zezula.net/download/listfiles.zip
-			fmt.Println(`getting file paths from bundled "Diablo II LOD.txt" listfile of Zezula's MPQ Editor`)
-			files, err := getFilePathsFromBundledListfile(archives, rawListfile)
+			infof("getting file paths from bundled %q listfile of Zezula's MPQ Editor\n", "Diablo II LOD.txt")
+			files, err := getFilePathsFromBundledListfile(archives, rawListfile, strictPaths)
 			if err != nil {
 				log.Fatalf("%+v", err)
 			}
@@ -125,35 +652,446 @@ func main() {
 		}
 	}
 
-	// De-normalize file paths.
-	for i, filePath := range filePaths {
-		filePaths[i] = denormalize(filePath)
+	// De-normalize file paths, skipping empty file paths (e.g. of a trailing
+	// comma in -files).
+	var nonEmpty []string
+	for _, filePath := range filePaths {
+		filePath = denormalize(filePath)
+		if len(filePath) == 0 {
+			opts.diagnostics.warn(diagEmptyPath, filePath, "skipping empty file path")
+			continue
+		}
+		nonEmpty = append(nonEmpty, filePath)
+	}
+	filePaths = nonEmpty
+
+	// Recover names of unnamed files from wordlist.
+	if len(wordlistPath) > 0 {
+		recovered, err := bruteforceNames(archives, filePaths, wordlistPath)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		filePaths = append(filePaths, recovered...)
+	}
+
+	// Resolve loose asset name to best-matching file paths.
+	if len(assetName) > 0 {
+		matches := fuzzyRank(assetName, filePaths, assetThreshold)
+		if len(matches) == 0 {
+			log.Fatalf("no file matching asset name %q with score of at least %.2f", assetName, assetThreshold)
+		}
+		filePaths = filePaths[:0]
+		for _, match := range matches {
+			if match.score < matches[0].score {
+				break
+			}
+			infof("asset %q matched %q (score %.2f)\n", assetName, recordedPath(match.filePath, opts), match.score)
+			filePaths = append(filePaths, match.filePath)
+		}
+	}
+
+	// Skip excluded file paths.
+	if len(rawExcludes) > 0 {
+		patterns, err := parseExcludePatterns(rawExcludes)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		filePaths = patterns.filter(filePaths)
+	}
+
+	// Skip files modified before cutoff time.
+	if len(rawSince) > 0 {
+		since, err := parseSince(rawSince)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		filePaths, err = filterSince(archives, filePaths, since)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	// Skip files outside of size range.
+	if len(rawMinSize) > 0 || len(rawMaxSize) > 0 {
+		bounds, err := parseSizeRange(rawMinSize, rawMaxSize)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		filePaths = bounds.filter(archives, filePaths)
+	}
+
+	// Randomly sample file paths.
+	if sampleSize > 0 {
+		if !isFlagSet("seed") {
+			seed = time.Now().UnixNano()
+		}
+		filePaths = sampleFiles(filePaths, sampleSize, seed)
+		infof("sampled %d file(s) using seed %d\n", len(filePaths), seed)
+		for _, filePath := range filePaths {
+			infof("sampled %q\n", normalize(filePath))
+		}
+	}
+
+	// Mount files as FUSE file system.
+	if len(mountDir) > 0 {
+		if err := mount(mountDir, archives, filePaths, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Report number of files not named by listfile.
+	if unnamedMode {
+		reportUnnamed(archives, filePaths)
+		return
+	}
+
+	// Browse files interactively.
+	if interactive {
+		if err := browse(archives, filePaths, outDir, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Print tally of files per extension.
+	if extReport {
+		printExtReport(archives, filePaths)
+		return
+	}
+
+	// Write index of file paths and sizes.
+	if len(csvPath) > 0 {
+		if err := writeCSVIndex(archives, filePaths, csvPath, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+	if len(tsvPath) > 0 {
+		if err := writeTSV(archives, filePaths, tsvPath, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Write base64 JSON dump of files.
+	if len(jsonDumpPath) > 0 {
+		if err := writeJSONDump(archives, filePaths, jsonDumpPath, jsonDumpLimit, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Write Go source embedding files.
+	if len(goPkg) > 0 {
+		if len(goPath) == 0 {
+			goPath = goPkg + ".go"
+		}
+		if err := writeGoSource(archives, filePaths, goPath, goPkg, goLimit, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Write single file to standard output.
+	if toStdout {
+		if err := writeStdout(archives, filePaths, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// Create empty placeholder files.
+	if touchOnly {
+		n, err := touchFiles(archives, filePaths, outDir, opts)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		fmt.Printf("created %d placeholder file(s) in %q\n", n, outDir)
+		return
 	}
 
 	// Extract files.
-	if err := extractAllFiles(archives, filePaths, lower); err != nil {
+	if len(rawSplitTypes) > 0 {
+		types, err := parseSplitTypes(rawSplitTypes)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		opts.splitTypes = types
+	}
+	if opts.jobs > 1 {
+		opts.locks = newArchiveLocks(archives)
+	}
+	if slowest > 0 {
+		opts.timings = &timings{}
+	}
+	if requireAll && len(rawFilePaths) > 0 {
+		opts.notFound = &[]string{}
+	}
+	if len(opts.typeFilter) > 0 {
+		if !isFileType(opts.typeFilter) {
+			log.Fatalf("invalid file type %q of -type; expected one of %s", opts.typeFilter, fileTypesHelp())
+		}
+		opts.typeCounts = &typeCounts{}
+	}
+	if len(manifestPath) > 0 {
+		opts.manifest = &manifest{root: outDir}
+	}
+
+	// Benchmark decompression throughput.
+	if bench {
+		if err := runBench(archives, filePaths, opts); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	root := outDir
+	if staging && !opts.dryRun {
+		if opts.existing == existingNoClobber {
+			log.Fatalf("-staging cannot be combined with -no-clobber")
+		}
+		if opts.existing == existingResume {
+			log.Fatalf("-staging cannot be combined with -resume-extract")
+		}
+		if len(sqlitePath) > 0 {
+			log.Fatalf("-staging cannot be combined with -sqlite")
+		}
+		if len(opts.tarPath) > 0 {
+			log.Fatalf("-staging cannot be combined with -tar")
+		}
+		if len(opts.zipPath) > 0 {
+			log.Fatalf("-staging cannot be combined with -zip")
+		}
+		stagingDir, err := newStagingDir(outDir)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		fmt.Printf("extracting into staging directory %q\n", stagingDir)
+		root = stagingDir
+	}
+	sink, err := newSink(root, sqlitePath, opts)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	summary, err := extractAllFiles(archives, filePaths, sink, opts)
+	if err == nil || opts.continueOnError {
+		// Keep the files extracted before errors when continuing on error.
+		if e := sink.Close(); err == nil {
+			err = e
+		}
+		if opts.modTimes != nil {
+			if e := opts.modTimes.preserveDirs(); err == nil {
+				err = e
+			}
+		}
+	}
+	fmt.Println(summary)
+	if opts.diagnostics != nil {
+		if err := opts.diagnostics.write(diagPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+	if opts.manifest != nil && err == nil {
+		if err := opts.manifest.write(manifestPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+	if err != nil {
+		if staging {
+			if cleanStaging {
+				if err := os.RemoveAll(root); err != nil {
+					log.Printf("unable to remove staging directory %q; %v\n", root, err)
+				}
+			} else {
+				log.Printf("extraction failed; staging directory left at %q\n", root)
+			}
+		}
 		log.Fatalf("%+v", err)
 	}
+	if staging {
+		if err := commitStaging(root, outDir); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		fmt.Printf("replaced %q with staging directory\n", outDir)
+	}
+	if opts.timings != nil {
+		opts.timings.printSlowest(slowest)
+	}
+	if opts.typeCounts != nil {
+		opts.typeCounts.print()
+	}
+	if opts.dryRun {
+		fmt.Printf("dry run; would have extracted %d file(s) (%d bytes)\n", sink.(*dirSink).files, summary.bytes)
+	}
+	if opts.notFound != nil && len(*opts.notFound) > 0 {
+		var missing []string
+		for _, filePath := range *opts.notFound {
+			missing = append(missing, fmt.Sprintf("%q", normalize(filePath)))
+		}
+		log.Fatalf("%d of %d requested file(s) not found: %s", len(missing), len(filePaths), strings.Join(missing, ", "))
+	}
+	if len(mpqURL) > 0 {
+		fmt.Printf("downloaded %d bytes, extracted %d bytes\n", downloaded, summary.bytes)
+	}
+}
+
+// options specifies how files are extracted from the MPQ archives.
+type options struct {
+	// Use lowercase for output file paths.
+	lower bool
+	// Log the byte offset within the MPQ archive of each extracted file.
+	showOffsets bool
+	// Retry language-neutral file when localized file fails to read.
+	localeFallback bool
+	// Output directory names of labelled MPQ archives.
+	labels map[*d2mpq.MPQ]string
+	// Prefix rewrite rules of output file paths.
+	rewrites rewriteRules
+	// Read durations of extracted files; nil if not recorded.
+	timings *timings
+	// Shell command to pipe the contents of each extracted file through.
+	pipeCmd string
+	// Extension of output files piped through pipeCmd; empty to keep the
+	// original extension.
+	pipeExt string
+	// Write SHA-256 sidecar file next to each extracted file.
+	sha256Sidecar bool
+	// Path separator style of file paths recorded in textual outputs.
+	pathStyle pathStyle
+	// Extract only files of the given detected file type; empty to extract
+	// files of any type.
+	typeFilter string
+	// Number of extracted files per detected file type; nil if not recorded.
+	typeCounts *typeCounts
+	// LRU cache of decompressed file contents; nil if disabled.
+	cache *readCache
+	// File paths not found in any of the MPQ archives during extraction; nil
+	// if not recorded.
+	notFound *[]string
+	// Number of files to extract in parallel.
+	jobs int
+	// Log, count and skip files which failed to extract, rather than aborting.
+	continueOnError bool
+	// Per-archive locks serializing reads from each MPQ archive; nil if reads
+	// need not be serialized.
+	locks archiveLocks
+	// Resolve and report output files without writing them.
+	dryRun bool
+	// Warnings emitted during extraction; nil if not recorded.
+	diagnostics *diagnostics
+	// Manifest of extracted files; nil if not recorded.
+	manifest *manifest
+	// Overwrite read-only destination files.
+	force bool
+	// File types of which each sub-element (e.g. DC6 frame) is extracted as a
+	// separate file; nil if files are not split.
+	splitTypes map[string]bool
+	// Fail on size mismatches between file contents and the block table.
+	strict bool
+	// Verifier of the CRC32 of extracted files; nil if not verified.
+	verify *crcVerifier
+	// Preserver of the modification time of extracted files; nil if not
+	// preserved.
+	modTimes *timePreserver
+	// Patch MPQ archives, which override the files of other MPQ archives.
+	patches map[*d2mpq.MPQ]bool
+	// Omit the output directory of MPQ archives from output paths.
+	flat bool
+	// In flat mode, overwrite files of earlier MPQ archives with files of
+	// later MPQ archives of the same path.
+	flatOverwrite bool
+	// Policy of files whose output path already exists.
+	existing existingPolicy
+	// Path of tar archive of extracted files; empty if not used.
+	tarPath string
+	// Path of zip archive of extracted files; empty if not used.
+	zipPath string
+}
+
+// isFlagSet reports whether the named command line flag was set.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// parseArchiveArg parses the given MPQ archive command line argument of the
+// form [LABEL=]FILE.mpq, and returns the label (if any) and the path of the MPQ
+// archive.
+func parseArchiveArg(arg string) (label, mpqPath string) {
+	pos := strings.Index(arg, "=")
+	if pos <= 0 || strings.ContainsAny(arg[:pos], `/\`) {
+		return "", arg
+	}
+	return arg[:pos], arg[pos+1:]
+}
+
+// archiveDir returns the output directory name of the given MPQ archive; i.e.
+// its label if labelled, and its base name otherwise.
+func archiveDir(archive *d2mpq.MPQ, opts options) string {
+	if label, ok := opts.labels[archive]; ok {
+		return label
+	}
+	return pathutil.FileName(archive.FileName)
+}
+
+// unionFilePaths returns the file paths of a followed by the file paths of b not
+// present in a, compared case-insensitively as in MPQ archives.
+func unionFilePaths(a, b []string) []string {
+	seen := make(map[string]bool)
+	var union []string
+	for _, filePaths := range [][]string{a, b} {
+		for _, filePath := range filePaths {
+			if seen[archivePath(filePath)] {
+				continue
+			}
+			seen[archivePath(filePath)] = true
+			union = append(union, filePath)
+		}
+	}
+	return union
 }
 
 // getFilePathsFromListfile returns the list of file paths contained within the
-// given listfile which are present in any of the MPQ archives.
-func getFilePathsFromListfile(archives []*d2mpq.MPQ, listfilePath string) ([]string, error) {
-	buf, err := ioutil.ReadFile(listfilePath)
+// given listfiles which are present in any of the MPQ archives. The listfiles are
+// concatenated in order, skipping duplicate file paths (compared
+// case-insensitively, as in MPQ archives).
+//
+// When strict is set, malformed listfile entries are reported and skipped (see
+// checkListfilePath). When includes is set, "@include" directives of the
+// listfile are merged recursively (see readListfile).
+func getFilePathsFromListfile(archives []*d2mpq.MPQ, listfilePaths []string, strict, includes bool) ([]string, error) {
+	entries, nlistfiles, err := readListfiles(listfilePaths, includes)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	s := bufio.NewScanner(bytes.NewReader(buf))
+	if nlistfiles > 1 {
+		infof("merged %d listfile entries from %d listfiles\n", len(entries), nlistfiles)
+	}
+	set := newNameHashSet(archives)
+	seen := make(map[string]bool)
 	var filePaths []string
-	for s.Scan() {
-		filePath := s.Text()
-		filePath = denormalize(filePath)
-		for _, archive := range archives {
-			if archive.FileExists(filePath) {
-				filePaths = append(filePaths, filePath)
-				break
+	for _, entry := range entries {
+		filePath := entry.filePath
+		if strict {
+			if err := checkListfilePath(filePath); err != nil {
+				log.Printf("malformed listfile entry %q on line %d of %q; %v\n", filePath, entry.line, entry.listfilePath, err)
+				continue
 			}
 		}
+		filePath = denormalize(filePath)
+		if seen[archivePath(filePath)] {
+			continue
+		}
+		seen[archivePath(filePath)] = true
+		if set.contains(filePath) {
+			filePaths = append(filePaths, filePath)
+		}
 	}
 	return filePaths, nil
 }
@@ -161,18 +1099,28 @@ func getFilePathsFromListfile(archives []*d2mpq.MPQ, listfilePath string) ([]str
 // getFilePathsFromBundledListfile returns the list of file paths contained
 // within the bundled "Diablo II LOD.txt" listfile of Zezula's MPQ Editor which
 // are present in any of the MPQ archives.
-func getFilePathsFromBundledListfile(archives []*d2mpq.MPQ, data string) ([]string, error) {
+//
+// When strict is set, malformed listfile entries are reported and skipped (see
+// checkListfilePath).
+func getFilePathsFromBundledListfile(archives []*d2mpq.MPQ, data string, strict bool) ([]string, error) {
 	s := bufio.NewScanner(strings.NewReader(data))
+	set := newNameHashSet(archives)
 	var filePaths []string
-	for s.Scan() {
-		filePath := s.Text()
-		filePath = denormalize(filePath)
-		for _, archive := range archives {
-			if archive.FileExists(filePath) {
-				filePaths = append(filePaths, filePath)
-				break
+	for line := 1; s.Scan(); line++ {
+		filePath := strings.TrimSpace(s.Text())
+		if len(filePath) == 0 {
+			continue
+		}
+		if strict {
+			if err := checkListfilePath(filePath); err != nil {
+				log.Printf("malformed listfile entry %q on line %d; %v\n", filePath, line, err)
+				continue
 			}
 		}
+		filePath = denormalize(filePath)
+		if set.contains(filePath) {
+			filePaths = append(filePaths, filePath)
+		}
 	}
 	return filePaths, nil
 }
@@ -192,85 +1140,370 @@ func getFilePathsFromEmbeddedListfile(archives []*d2mpq.MPQ) ([]string, error) {
 }
 
 // extractAllFiles extracts all files specified by file path from the MPQ
-// archives.
-func extractAllFiles(archives []*d2mpq.MPQ, filePaths []string, lower bool) error {
+// archives, and returns a summary of the extraction results.
+//
+// Labelled MPQ archives are extracted independently of each other; i.e. a file
+// is extracted from every labelled MPQ archive containing it, and from the
+// first unlabelled MPQ archive containing it.
+//
+// When opts.jobs is greater than 1, files are extracted in parallel (see
+// extractAllFilesParallel).
+func extractAllFiles(archives []*d2mpq.MPQ, filePaths []string, sink Sink, opts options) (extractSummary, error) {
+	groups := archiveGroups(archives, opts)
+	if opts.jobs > 1 {
+		return extractAllFilesParallel(groups, filePaths, sink, opts)
+	}
+	var summary extractSummary
 	for _, filePath := range filePaths {
-		if err := extractFile(archives, filePath, lower); err != nil {
+		result := extractFileGroups(groups, filePath, sink, opts)
+		summary.add(result)
+		if result.err != nil {
+			return summary, errors.WithStack(result.err)
+		}
+		if !result.found {
+			recordNotFound(filePath, opts)
+		}
+	}
+	if err := summary.failure(opts); err != nil {
+		return summary, errors.WithStack(err)
+	}
+	return summary, nil
+}
+
+// extractFileGroups extracts the file from each group of MPQ archives (see
+// archiveGroups), and returns the result of the extraction. File read errors
+// are logged, counted and skipped; as are all other errors if
+// opts.continueOnError is set.
+func extractFileGroups(groups [][]*d2mpq.MPQ, filePath string, sink Sink, opts options) extractResult {
+	result := extractResult{filePath: filePath}
+	for _, group := range groups {
+		n, written, err := safeExtractFile(group, filePath, sink, opts)
+		result.n += int64(n)
+		if err != nil {
 			switch errors.Cause(err) {
 			case ErrNotFound:
-				log.Printf("file not found %q\n", filePath)
 				continue
 			case ErrFileRead:
-				log.Printf("file read error %q; %+v\n", filePath, err)
+				result.found = true
+				result.readErrors++
+				opts.diagnostics.warn(diagReadError, filePath, "file read error %q; %+v", filePath, err)
 				continue
 			}
+			result.found = true
+			if opts.continueOnError {
+				result.failed++
+				opts.diagnostics.warn(diagExtractError, filePath, "unable to extract %q; %+v", filePath, err)
+				continue
+			}
+			result.err = errors.WithStack(err)
+			return result
+		}
+		result.found = true
+		if !written {
+			result.skipped++
+			continue
+		}
+		result.extracted++
+	}
+	return result
+}
+
+// recordNotFound logs that the given file was not found in any of the MPQ
+// archives, and records it in opts.notFound if set.
+func recordNotFound(filePath string, opts options) {
+	opts.diagnostics.warn(diagNotFound, filePath, "file not found %q", filePath)
+	if opts.notFound != nil {
+		*opts.notFound = append(*opts.notFound, filePath)
+	}
+	if opts.manifest != nil {
+		opts.manifest.addNotFound(filePath)
+	}
+}
+
+// extractListfiles extracts the embedded (listfile) of each MPQ archive into
+// the output directory of the MPQ archive.
+func extractListfiles(archives []*d2mpq.MPQ, sink Sink, opts options) error {
+	const listfileName = "(listfile)"
+	for _, archive := range archives {
+		infof("extracting %q from %q\n", listfileName, archive.FileName)
+		if !archive.FileExists(listfileName) {
+			log.Printf("file not found %q in %q\n", listfileName, archive.FileName)
+			continue
+		}
+		data, err := archiveReadFile(archive, listfileName)
+		if err != nil {
+			if errors.Cause(err) == ErrFileRead {
+				log.Printf("file read error %q in %q; %+v\n", listfileName, archive.FileName, err)
+				continue
+			}
+			return errors.WithStack(err)
+		}
+		dir := archiveDir(archive, opts)
+		if opts.lower {
+			dir = strings.ToLower(dir)
+		}
+		if err := sink.WriteFile(dir, listfileName, data); err != nil && errors.Cause(err) != ErrSkipped {
 			return errors.WithStack(err)
 		}
 	}
 	return nil
 }
 
+// archiveGroups partitions the MPQ archives into groups which are extracted
+// independently of each other; one group of all unlabelled MPQ archives and
+// one group per labelled MPQ archive.
+func archiveGroups(archives []*d2mpq.MPQ, opts options) [][]*d2mpq.MPQ {
+	var unlabelled []*d2mpq.MPQ
+	var groups [][]*d2mpq.MPQ
+	for _, archive := range archives {
+		if _, ok := opts.labels[archive]; ok {
+			groups = append(groups, []*d2mpq.MPQ{archive})
+			continue
+		}
+		unlabelled = append(unlabelled, archive)
+	}
+	if len(unlabelled) > 0 {
+		groups = append([][]*d2mpq.MPQ{unlabelled}, groups...)
+	}
+	return groups
+}
+
+// safeExtractFile extracts the file as done by extractFile, recovering from any
+// panic during extraction (e.g. while decompressing, piping or writing the
+// file) by reporting it as an ErrFileRead of the file. This ensures that one bad
+// file never stops the extraction of the remaining files.
+func safeExtractFile(archives []*d2mpq.MPQ, filePath string, sink Sink, opts options) (n int, written bool, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			n, written, err = 0, false, errors.Wrapf(ErrFileRead, "panic during extraction of %q; %v", filePath, e)
+		}
+	}()
+	return extractFile(archives, filePath, sink, opts)
+}
+
 // extractFile extracts the file from first MPQ archive containing the file
-// path.
-func extractFile(archives []*d2mpq.MPQ, filePath string, lower bool) error {
-	fmt.Printf("extracting %q\n", filePath)
-	data, archiveName, err := readFile(archives, filePath)
+// path, and returns the number of bytes extracted and whether the file was
+// written; skipped files (e.g. by -type, -no-clobber or -resume-extract) are not.
+func extractFile(archives []*d2mpq.MPQ, filePath string, sink Sink, opts options) (int, bool, error) {
+	infof("extracting %q\n", filePath)
+	if skipExisting(archives, filePath, sink, opts) {
+		return 0, false, nil
+	}
+	if s, ok := sink.(streamSink); ok && canStream(opts) {
+		return streamFile(archives, filePath, s, opts)
+	}
+	start := time.Now()
+	data, archive, err := readFile(archives, filePath, opts)
 	if err != nil {
-		return errors.WithStack(err)
+		return 0, false, errors.WithStack(err)
+	}
+	if opts.timings != nil {
+		opts.timings.add(fileTiming{filePath: filePath, archive: archive, elapsed: time.Since(start), size: len(data)})
+	}
+	if err := checkFileSize(archive, filePath, len(data), opts); err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	if opts.verify != nil {
+		if err := opts.verify.verify(archive, filePath, data); err != nil {
+			return 0, false, errors.WithStack(err)
+		}
+	}
+	reportPrecedence(archives, filePath, archive, opts)
+	if opts.typeCounts != nil {
+		fileType := detectType(data)
+		if len(opts.typeFilter) > 0 && fileType != opts.typeFilter {
+			infof("skipping %q; detected type %q\n", filePath, fileType)
+			return 0, false, nil
+		}
+		opts.typeCounts.add(fileType)
+	}
+	if opts.showOffsets {
+		block, _ := archive.FileBlock(archivePath(filePath))
+		infof("offset: 0x%08X\n", block.Position())
+	}
+	dir := archiveDir(archive, opts)
+	if opts.lower {
+		dir = strings.ToLower(dir)
+	}
+	dstPath := outputPath(filePath, opts)
+	if len(opts.pipeCmd) > 0 {
+		data, err = pipeFile(opts.pipeCmd, filePath, data)
+		if err != nil {
+			return 0, false, errors.WithStack(err)
+		}
+		if len(opts.pipeExt) > 0 {
+			dstPath = replaceExt(dstPath, opts.pipeExt)
+		}
 	}
-	archiveDir := pathutil.FileName(archiveName)
-	dstPath := normalize(filepath.Join("_dump_", archiveDir, filePath))
-	if lower {
-		dstPath = strings.ToLower(dstPath)
+	if opts.splitTypes != nil {
+		if fileType := detectType(data); opts.splitTypes[fileType] {
+			parts, err := splitters[fileType](data)
+			if err == nil {
+				written := false
+				for i, part := range parts {
+					if err := sink.WriteFile(dir, splitPath(dstPath, i), part); err != nil {
+						if errors.Cause(err) == ErrSkipped {
+							continue
+						}
+						return 0, false, errors.WithStack(err)
+					}
+					written = true
+					if opts.modTimes != nil {
+						if err := opts.modTimes.preserve(archive, filePath, sink, dir, splitPath(dstPath, i)); err != nil {
+							return 0, false, errors.WithStack(err)
+						}
+					}
+				}
+				if !written {
+					return 0, false, nil
+				}
+				if opts.manifest != nil {
+					opts.manifest.addFile(archive, filePath, dir, dstPath, len(data))
+				}
+				return len(data), true, nil
+			}
+			log.Printf("unable to split %q; extracting as one file; %v\n", filePath, err)
+		}
+	}
+	if err := sink.WriteFile(dir, dstPath, data); err != nil {
+		if errors.Cause(err) == ErrSkipped {
+			return 0, false, nil
+		}
+		return 0, false, errors.WithStack(err)
 	}
-	fmt.Printf("creating: %q\n", dstPath)
-	dir := filepath.Dir(dstPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return errors.WithStack(err)
+	if opts.modTimes != nil {
+		if err := opts.modTimes.preserve(archive, filePath, sink, dir, dstPath); err != nil {
+			return 0, false, errors.WithStack(err)
+		}
 	}
-	if err := ioutil.WriteFile(dstPath, data, 0644); err != nil {
-		return errors.WithStack(err)
+	if opts.manifest != nil {
+		opts.manifest.addFile(archive, filePath, dir, dstPath, len(data))
 	}
+	return len(data), true, nil
+}
+
+// checkFileSize checks the size in bytes of the given file contents read from
+// the MPQ archive against the uncompressed size recorded in the block table. A
+// size mismatch is logged as a warning, or reported as an ErrSizeMismatch error
+// if opts.strict is set.
+func checkFileSize(archive *d2mpq.MPQ, filePath string, size int, opts options) error {
+	expected, ok := archive.FileSize(archivePath(filePath))
+	if !ok || uint32(size) == expected {
+		return nil
+	}
+	if opts.strict {
+		return errors.Wrapf(ErrSizeMismatch, "size of %q is %d bytes; expected %d bytes", filePath, size, expected)
+	}
+	opts.diagnostics.warn(diagSizeMismatch, filePath, "size mismatch of %q; read %d bytes, expected %d bytes", filePath, size, expected)
 	return nil
 }
 
 // readFile reads the contents of the given file from the first MPQ archive
-// containing the file path.
-func readFile(archives []*d2mpq.MPQ, filePath string) ([]byte, string, error) {
-	// de-normalize file name.
-	filePath = strings.ToLower(filePath)
-	filePath = strings.ReplaceAll(filePath, `/`, "\\")
-	if filePath[0] == '\\' {
-		filePath = filePath[1:]
+// containing the file path, and returns the contents along with the MPQ
+// archive. The MPQ archives are searched in order, as sorted by sortArchives.
+//
+// If the localized file fails to read and opts.localeFallback is set, the
+// language-neutral file of the same path is read instead. If opts.cache is set,
+// decompressed contents are cached.
+func readFile(archives []*d2mpq.MPQ, filePath string, opts options) ([]byte, *d2mpq.MPQ, error) {
+	if len(filePath) == 0 {
+		return nil, nil, errors.Wrap(ErrNotFound, "empty file path")
 	}
+	filePath = archivePath(filePath)
 	// search for MPQ archive containing file.
 	for _, archive := range archives {
 		if !archive.FileExists(filePath) {
 			continue
 		}
-		data, err := archiveReadFile(archive, filePath)
+		if opts.cache != nil {
+			if data, ok := opts.cache.get(archive, filePath); ok {
+				return data, archive, nil
+			}
+		}
+		data, err := lockedReadFile(archive, filePath, opts)
 		if err != nil {
-			return nil, "", errors.WithStack(err)
+			return nil, nil, errors.WithStack(err)
 		}
-		return data, archive.FileName, nil
+		if opts.cache != nil {
+			opts.cache.put(archive, filePath, data)
+		}
+		return data, archive, nil
 	}
-	return nil, "", errors.Wrapf(ErrNotFound, "file not found %q", filePath)
+	return nil, nil, errors.Wrapf(ErrNotFound, "file not found %q", filePath)
 }
 
-// archiveReadFile reads the contents of the given file from the MPQ archive.
-func archiveReadFile(archive *d2mpq.MPQ, filePath string) (data []byte, err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			err = errors.Wrap(ErrFileRead, fmt.Sprint(e))
+// lockedReadFile reads the contents of the given file from the MPQ archive
+// while holding the lock of the archive, falling back to the language-neutral
+// file on read errors if opts.localeFallback is set. The lock is released even
+// if reading the file panics, so that other workers reading the archive are not
+// blocked.
+func lockedReadFile(archive *d2mpq.MPQ, filePath string, opts options) ([]byte, error) {
+	opts.locks.lock(archive)
+	defer opts.locks.unlock(archive)
+	data, err := archiveReadFile(archive, filePath)
+	if err != nil && opts.localeFallback && errors.Cause(err) == ErrFileRead {
+		if neutral, ok := neutralArchive(archive, filePath); ok {
+			opts.diagnostics.warn(diagLocaleFallback, filePath, "localized file read error %q; falling back to language-neutral file; %v", filePath, err)
+			data, err = archiveReadFile(neutral, filePath)
 		}
-	}()
-	data, err = archive.ReadFile(filePath)
-	if err != nil {
-		return nil, errors.WithStack(err)
 	}
 	return data, err
 }
 
+// archiveReadFile reads the contents of the given file from the MPQ archive.
+// Errors reading the file contents (e.g. of corrupt sectors) are reported as
+// ErrFileRead. It is a variable so that tests may inject failures.
+var archiveReadFile = func(archive *d2mpq.MPQ, filePath string) ([]byte, error) {
+	data, err := archive.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrap(ErrFileRead, err.Error())
+	}
+	return data, nil
+}
+
+// outputPath returns the normalized output path of the given file, with
+// lowercase and rewrite rules applied as specified by opts.
+func outputPath(filePath string, opts options) string {
+	outPath := normalize(filePath)
+	if opts.lower {
+		outPath = strings.ToLower(outPath)
+	}
+	return opts.rewrites.apply(outPath)
+}
+
+// archivePath returns the file path as stored in MPQ archives; i.e.
+// lowercase, backslash-separated and without leading backslash.
+func archivePath(filePath string) string {
+	filePath = strings.ToLower(filePath)
+	filePath = strings.ReplaceAll(filePath, `/`, "\\")
+	if len(filePath) > 0 && filePath[0] == '\\' {
+		filePath = filePath[1:]
+	}
+	return filePath
+}
+
+// checkListfilePath reports whether the given listfile entry is malformed; i.e.
+// empty, an absolute Windows path, prefixed by a drive letter, or containing
+// NUL or other control characters.
+func checkListfilePath(filePath string) error {
+	switch {
+	case len(strings.TrimSpace(filePath)) == 0:
+		return errors.New("empty path")
+	case strings.HasPrefix(filePath, `\\`) || strings.HasPrefix(filePath, "//"):
+		return errors.New("absolute UNC path")
+	case len(filePath) >= 2 && filePath[1] == ':' && ('a' <= filePath[0] && filePath[0] <= 'z' || 'A' <= filePath[0] && filePath[0] <= 'Z'):
+		return errors.New("path prefixed by drive letter")
+	case strings.ContainsRune(filePath, 0):
+		return errors.New("embedded NUL character")
+	}
+	for _, r := range filePath {
+		if r < 0x20 || r == 0x7F {
+			return errors.Errorf("control character %U", r)
+		}
+	}
+	return nil
+}
+
 // normalize normalizes the file path by replacing backslash characters with
 // slash.
 func normalize(filePath string) string {
@@ -279,8 +1512,12 @@ func normalize(filePath string) string {
 }
 
 // denormalize de-normalizes the file path by replacing slash characters with
-// backslashes and removing any leading slash prefix.
+// backslashes and removing any leading slash prefix. The empty string is
+// returned as is.
 func denormalize(filePath string) string {
+	if len(filePath) == 0 {
+		return filePath
+	}
 	filePath = strings.ReplaceAll(filePath, "/", `\`)
 	if strings.HasPrefix(filePath, `\`) {
 		filePath = filePath[len(`\`):]
@@ -289,6 +1526,9 @@ func denormalize(filePath string) string {
 }
 
 var (
-	ErrNotFound = errors.New("unable to locate MPQ archive")
-	ErrFileRead = errors.New("unable to read file contents")
+	ErrNotFound     = errors.New("unable to locate MPQ archive")
+	ErrFileRead     = errors.New("unable to read file contents")
+	ErrSkipped      = errors.New("file skipped")
+	ErrSizeMismatch = errors.New("size of file contents differs from block table")
+	ErrCRCMismatch  = errors.New("CRC32 of file contents differs from (attributes)")
 )
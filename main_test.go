@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/OpenDiablo2/MpqViewer/internal/mpqtest"
+)
+
+func TestMain(m *testing.M) {
+	d2mpq.InitializeCryptoBuffer()
+	os.Exit(m.Run())
+}
+
+// Contents of test files.
+var (
+	books     = bytes.Repeat([]byte("Name\tNamco\tCompleted\n"), 100)
+	charstats = bytes.Repeat([]byte("class\tstr\tdex\tint\tvit\n"), 100)
+)
+
+// testArchive is an MPQ archive of a test, written to the given file name.
+type testArchive struct {
+	name string
+	mpqtest.Archive
+}
+
+// loadTestArchives writes the given MPQ archives to dir, and loads them in
+// order.
+func loadTestArchives(t testing.TB, dir string, archives ...testArchive) []*d2mpq.MPQ {
+	t.Helper()
+	var loaded []*d2mpq.MPQ
+	for _, a := range archives {
+		archive, err := loadArchive(a.Write(t, dir, a.name), false)
+		if err != nil {
+			t.Fatalf("unable to load MPQ archive %q; %+v", a.name, err)
+		}
+		loaded = append(loaded, archive)
+	}
+	return loaded
+}
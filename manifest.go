@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// manifestEntry records the extraction of a file, as written to the manifest of
+// -manifest.
+type manifestEntry struct {
+	// Original (backslash-separated) file path within the MPQ archive.
+	Path string `json:"path"`
+	// File name of the MPQ archive containing the file; empty if not found.
+	Archive string `json:"archive"`
+	// Destination path of the extracted file; empty if not found.
+	Dest string `json:"dest"`
+	// Uncompressed size in bytes of the file.
+	Size int `json:"size"`
+	// Byte offset of the file within the MPQ archive.
+	Offset int64 `json:"offset"`
+	// Specifies whether the file was found in any of the MPQ archives.
+	Found bool `json:"found"`
+}
+
+// manifest records the files extracted during extraction. It is safe for
+// concurrent use.
+type manifest struct {
+	// Root directory of output files.
+	root    string
+	mu      sync.Mutex
+	entries []manifestEntry
+}
+
+// addFile records the extraction of the given file from the MPQ archive to the
+// output path dstPath below the output directory archiveDir.
+func (m *manifest) addFile(archive *d2mpq.MPQ, filePath, archiveDir, dstPath string, size int) {
+	block, _ := archive.FileBlock(archivePath(filePath))
+	entry := manifestEntry{
+		Path:    filePath,
+		Archive: archive.FileName,
+		Dest:    normalize(filepath.Join(m.root, archiveDir, dstPath)),
+		Size:    size,
+		Offset:  block.Position(),
+		Found:   true,
+	}
+	m.mu.Lock()
+	m.entries = append(m.entries, entry)
+	m.mu.Unlock()
+}
+
+// addNotFound records that the given file was not found in any of the MPQ
+// archives.
+func (m *manifest) addNotFound(filePath string) {
+	m.mu.Lock()
+	m.entries = append(m.entries, manifestEntry{Path: filePath})
+	m.mu.Unlock()
+}
+
+// write writes the recorded entries as a JSON array to the given path, sorted
+// by file path and MPQ archive so that manifests of different extractions may
+// be diffed.
+func (m *manifest) write(manifestPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.entries
+	if entries == nil {
+		entries = []manifestEntry{}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Path != entries[j].Path {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].Archive < entries[j].Archive
+	})
+	buf, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(manifestPath, append(buf, '\n'), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Printf("wrote manifest of %d file(s) to %q\n", len(entries), manifestPath)
+	return nil
+}
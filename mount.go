@@ -0,0 +1,121 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// mount mounts the given files of the MPQ archives as a read-only FUSE file
+// system at the given directory, and serves it until unmounted or
+// interrupted. File contents are decompressed on demand when read.
+func mount(dir string, archives []*d2mpq.MPQ, filePaths []string, opts options) error {
+	c, err := fuse.Mount(dir, fuse.FSName("mpq"), fuse.Subtype("mpqviewer"), fuse.ReadOnly())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer c.Close()
+	// Unmount on interrupt.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		if err := fuse.Unmount(dir); err != nil {
+			log.Printf("unable to unmount %q; %v\n", dir, err)
+		}
+	}()
+	mfs := &mountFS{
+		root:     buildTree(filePaths, opts.lower),
+		archives: archives,
+		opts:     opts,
+	}
+	log.Printf("mounted MPQ archives at %q\n", dir)
+	if err := fs.Serve(c, mfs); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// mountFS is a read-only FUSE file system of files in MPQ archives.
+type mountFS struct {
+	// Directory tree of files.
+	root *treeNode
+	// MPQ archives containing files.
+	archives []*d2mpq.MPQ
+	// Extraction options.
+	opts options
+	// Serializes reads from the MPQ archives, as d2mpq.MPQ is not safe for
+	// concurrent use.
+	mu sync.Mutex
+}
+
+// Root returns the root directory of the file system.
+func (mfs *mountFS) Root() (fs.Node, error) {
+	return &mountNode{mfs: mfs, node: mfs.root}, nil
+}
+
+// mountNode is a file or directory of the FUSE file system.
+type mountNode struct {
+	// File system of node.
+	mfs *mountFS
+	// Tree node of file or directory.
+	node *treeNode
+}
+
+// Attr returns the attributes of the file or directory.
+func (n *mountNode) Attr(ctx context.Context, attr *fuse.Attr) error {
+	if n.node.isDir() {
+		attr.Mode = os.ModeDir | 0555
+		return nil
+	}
+	attr.Mode = 0444
+	if size, ok := lookupFileSize(n.mfs.archives, n.node.filePath); ok {
+		attr.Size = uint64(size)
+	}
+	return nil
+}
+
+// Lookup returns the child node of the directory with the given name.
+func (n *mountNode) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	child, ok := n.node.children[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return &mountNode{mfs: n.mfs, node: child}, nil
+}
+
+// ReadDirAll returns the entries of the directory.
+func (n *mountNode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	var entries []fuse.Dirent
+	for _, child := range n.node.sortedChildren() {
+		typ := fuse.DT_File
+		if child.isDir() {
+			typ = fuse.DT_Dir
+		}
+		entries = append(entries, fuse.Dirent{Name: child.name, Type: typ})
+	}
+	return entries, nil
+}
+
+// ReadAll returns the decompressed contents of the file.
+func (n *mountNode) ReadAll(ctx context.Context) ([]byte, error) {
+	n.mfs.mu.Lock()
+	defer n.mfs.mu.Unlock()
+	data, _, err := readFile(n.mfs.archives, n.node.filePath, n.mfs.opts)
+	if err != nil {
+		log.Printf("file read error %q; %+v\n", n.node.filePath, err)
+		return nil, fuse.EIO
+	}
+	return data, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// mount is not supported on this platform.
+func mount(dir string, archives []*d2mpq.MPQ, filePaths []string, opts options) error {
+	return errors.New("-mount is only supported on Linux")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// nameHash is the pair of file name hashes identifying a file in the hash table
+// of an MPQ archive.
+type nameHash struct {
+	a, b uint32
+}
+
+// nameHashSet is a set of the file name hashes present in the hash tables of
+// MPQ archives, for fast lookup of whether a file exists in any of the MPQ
+// archives.
+type nameHashSet map[nameHash]struct{}
+
+// newNameHashSet returns the set of file name hashes present in the hash
+// tables of the given MPQ archives. As by fileHashes, hash table entries
+// referencing a non-existent block or a block without the FileExists flag are
+// omitted.
+func newNameHashSet(archives []*d2mpq.MPQ) nameHashSet {
+	set := make(nameHashSet)
+	for _, archive := range archives {
+		for _, hash := range fileHashes(archive) {
+			set[hash] = struct{}{}
+		}
+	}
+	return set
+}
+
+// contains reports whether the given file has a hash table entry referencing an
+// existing block in any of the MPQ archives of the set. Unlike FileExists, the
+// hash tables are not probed; so entries past a free entry of the probe
+// sequence, which only occur in malformed hash tables, are also found.
+func (set nameHashSet) contains(filePath string) bool {
+	_, hashA, hashB := d2mpq.HashFileName(filePath)
+	_, ok := set[nameHash{a: hashA, b: hashB}]
+	return ok
+}
+
+// localeNeutral is the locale of language-neutral files.
+const localeNeutral = 0
+
+// neutralArchive returns a shallow copy of the MPQ archive in which the given
+// file resolves to its language-neutral hash table entry, and a boolean
+// indicating whether the file has both a localized and a language-neutral
+// entry in the archive. The localized entries are marked as deleted in the
+// copy, so that the positions of the remaining entries are kept.
+func neutralArchive(archive *d2mpq.MPQ, filePath string) (*d2mpq.MPQ, bool) {
+	_, hashA, hashB := d2mpq.HashFileName(filePath)
+	entries := make([]d2mpq.HashTableEntry, len(archive.HashTableEntries))
+	copy(entries, archive.HashTableEntries)
+	hasNeutral, hasLocalized := false, false
+	for i, entry := range entries {
+		if entry.NamePartA != hashA || entry.NamePartB != hashB || entry.BlockIndex == d2mpq.BlockIndexFree || entry.BlockIndex == d2mpq.BlockIndexDeleted {
+			continue
+		}
+		if entry.Locale != localeNeutral {
+			hasLocalized = true
+			entries[i].BlockIndex = d2mpq.BlockIndexDeleted
+			continue
+		}
+		hasNeutral = true
+	}
+	if !hasNeutral || !hasLocalized {
+		return nil, false
+	}
+	neutral := *archive
+	neutral.HashTableEntries = entries
+	return &neutral, true
+}
+
+// loadArchive loads the given MPQ archive. d2mpq.ErrCryptoUninitialized is
+// returned if the crypto buffer has not yet been initialized.
+//
+// When protected is set, un-protection heuristics are applied to archives which
+// have been altered to thwart MPQ tools (see d2mpq.LoadProtected), and the
+// heuristics applied are logged.
+func loadArchive(mpqPath string, protected bool) (*d2mpq.MPQ, error) {
+	if protected {
+		archive, err := d2mpq.LoadProtected(mpqPath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, repair := range archive.Repairs {
+			log.Printf("protected archive %q: %s\n", mpqPath, repair)
+		}
+		return archive, nil
+	}
+	archive, err := d2mpq.Load(mpqPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return archive, nil
+}
+
+// compressionNames maps from compression method mask to name.
+var compressionNames = []struct {
+	mask byte
+	name string
+}{
+	{mask: d2mpq.CompressionHuffman, name: "huffman"},
+	{mask: d2mpq.CompressionZlib, name: "zlib"},
+	{mask: d2mpq.CompressionPKLib, name: "pkware"},
+	{mask: d2mpq.CompressionBZip2, name: "bzip2"},
+	{mask: d2mpq.CompressionSparse, name: "sparse"},
+	{mask: d2mpq.CompressionADPCMMono, name: "adpcm-mono"},
+	{mask: d2mpq.CompressionADPCMStereo, name: "adpcm-stereo"},
+}
+
+// compressionMethod returns a description of the compression method of the
+// given block, as determined by its flags and the compression mask of its first
+// sector.
+func compressionMethod(archive *d2mpq.MPQ, block d2mpq.BlockTableEntry) string {
+	switch {
+	case block.HasFlag(d2mpq.FileImplode):
+		return "implode"
+	case !block.HasFlag(d2mpq.FileCompress):
+		return "none"
+	case block.HasFlag(d2mpq.FileEncrypted):
+		return "compressed (encrypted)"
+	}
+	// Locate first sector.
+	sectorSize := uint32(0x200) << archive.Data.BlockSize
+	offset := block.Position()
+	size := block.CompressedFileSize
+	expected := block.UncompressedFileSize
+	if !block.HasFlag(d2mpq.FileSingleUnit) {
+		var buf [8]byte
+		if _, err := archive.File.ReadAt(buf[:], offset); err != nil {
+			return "compressed"
+		}
+		start := binary.LittleEndian.Uint32(buf[0:4])
+		end := binary.LittleEndian.Uint32(buf[4:8])
+		offset += int64(start)
+		size = end - start
+		if expected > sectorSize {
+			expected = sectorSize
+		}
+	}
+	if size >= expected {
+		return "none"
+	}
+	var mask [1]byte
+	if _, err := archive.File.ReadAt(mask[:], offset); err != nil {
+		return "compressed"
+	}
+	var names []string
+	for _, c := range compressionNames {
+		if mask[0]&c.mask != 0 {
+			names = append(names, c.name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("unknown (0x%02X)", mask[0])
+	}
+	return strings.Join(names, "+")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/OpenDiablo2/MpqViewer/internal/mpqtest"
+)
+
+func TestNeutralArchive(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	golden := []struct {
+		name  string
+		files []mpqtest.File
+		ok    bool
+	}{
+		{
+			name: "localized and neutral",
+			files: []mpqtest.File{
+				{Name: booksPath, Data: charstats, Locale: 0x0409},
+				{Name: booksPath, Data: books},
+			},
+			ok: true,
+		},
+		{name: "neutral only", files: []mpqtest.File{{Name: booksPath, Data: books}}, ok: false},
+		{name: "localized only", files: []mpqtest.File{{Name: booksPath, Data: books, Locale: 0x0409}}, ok: false},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: g.files, HashTableSize: 4}
+			archives := loadTestArchives(t, t.TempDir(), testArchive{name: "d2data.mpq", Archive: a})
+			neutral, ok := neutralArchive(archives[0], booksPath)
+			if ok != g.ok {
+				t.Fatalf("neutral archive mismatch; expected %v, got %v", g.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			entry, ok := neutral.FileHashEntry(booksPath)
+			if !ok {
+				t.Fatalf("file %q not found in neutral archive", booksPath)
+			}
+			if locale := entry.Locale; locale != localeNeutral {
+				t.Errorf("locale mismatch; expected 0x%04X, got 0x%04X", localeNeutral, locale)
+			}
+			if len(neutral.HashTableEntries) != len(archives[0].HashTableEntries) {
+				t.Errorf("hash table size mismatch; expected %d, got %d", len(archives[0].HashTableEntries), len(neutral.HashTableEntries))
+			}
+		})
+	}
+}
+
+func TestNameHashSet(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	golden := []struct {
+		name string
+		// Modifies the block table entry of books.txt.
+		modify func(archive *d2mpq.MPQ, blockIndex uint32)
+		want   bool
+	}{
+		{name: "existing", want: true},
+		{
+			name: "block without FileExists flag",
+			modify: func(archive *d2mpq.MPQ, blockIndex uint32) {
+				archive.BlockTableEntries[blockIndex].Flags &^= d2mpq.FileExists
+			},
+			want: false,
+		},
+		{
+			name: "block index out of range",
+			modify: func(archive *d2mpq.MPQ, blockIndex uint32) {
+				archive.BlockTableEntries = archive.BlockTableEntries[:blockIndex]
+			},
+			want: false,
+		},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			a := mpqtest.Archive{Files: []mpqtest.File{
+				{Name: `data\global\excel\charstats.txt`, Data: charstats},
+				{Name: booksPath, Data: books},
+			}}
+			archives := loadTestArchives(t, t.TempDir(), testArchive{name: "d2data.mpq", Archive: a})
+			if g.modify != nil {
+				entry, ok := archives[0].FileHashEntry(booksPath)
+				if !ok {
+					t.Fatalf("file %q not found", booksPath)
+				}
+				g.modify(archives[0], entry.BlockIndex)
+			}
+			if got := newNameHashSet(archives).contains(booksPath); got != g.want {
+				t.Errorf("contains mismatch; expected %v, got %v", g.want, got)
+			}
+		})
+	}
+}
+
+// BenchmarkBundledListfile compares looking up the files of the bundled
+// listfile by probing the hash table of each MPQ archive (FileExists) with
+// looking them up in the set of file name hashes of the MPQ archives.
+func BenchmarkBundledListfile(b *testing.B) {
+	var lines []string
+	for _, line := range strings.Split(rawListfile, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, denormalize(line))
+		}
+	}
+	// Store every tenth file of the listfile in one of two MPQ archives.
+	var files [2][]mpqtest.File
+	for i := 0; i < len(lines); i += 10 {
+		files[i/10%2] = append(files[i/10%2], mpqtest.File{Name: lines[i], Data: []byte(lines[i])})
+	}
+	dir := b.TempDir()
+	archives := loadTestArchives(b, dir,
+		testArchive{name: "d2data.mpq", Archive: mpqtest.Archive{Files: files[0]}},
+		testArchive{name: "d2exp.mpq", Archive: mpqtest.Archive{Files: files[1]}},
+	)
+	want := len(files[0]) + len(files[1])
+
+	golden := []struct {
+		name string
+		// Returns the number of lines of files present in any MPQ archive.
+		count func() int
+	}{
+		{
+			name: "FileExists",
+			count: func() int {
+				n := 0
+				for _, line := range lines {
+					for _, archive := range archives {
+						if archive.FileExists(line) {
+							n++
+							break
+						}
+					}
+				}
+				return n
+			},
+		},
+		{
+			name: "nameHashSet",
+			count: func() int {
+				set := newNameHashSet(archives)
+				n := 0
+				for _, line := range lines {
+					if set.contains(line) {
+						n++
+					}
+				}
+				return n
+			},
+		},
+	}
+	for _, g := range golden {
+		b.Run(g.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if got := g.count(); got != want {
+					b.Fatalf("file count mismatch; expected %d, got %d", want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// normalizeOutput renames the files and directories of an existing output
+// directory to their canonical form; i.e. lowercase, with any backslashes of
+// file names treated as path separators.
+//
+// A file whose canonical path is already taken by another file is merged (i.e.
+// removed) if the contents of both files are identical, and reported as a
+// collision otherwise. Directories left empty are removed. On case-insensitive
+// file systems, files and directories differing only in case are renamed in two
+// steps through a temporary name.
+func normalizeOutput(root string) error {
+	// Rename files.
+	var filePaths []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !info.IsDir() {
+			filePaths = append(filePaths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	renamed, merged, collisions := 0, 0, 0
+	for _, path := range filePaths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		canonical := canonicalPath(rel)
+		if canonical == filepath.ToSlash(rel) {
+			continue
+		}
+		dstPath := filepath.Join(root, filepath.FromSlash(canonical))
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return errors.WithStack(err)
+		}
+		switch status, err := renameCanonical(path, dstPath); {
+		case err != nil:
+			return errors.WithStack(err)
+		case status == renameMerged:
+			fmt.Printf("merging: %q into %q\n", path, dstPath)
+			merged++
+		case status == renameCollision:
+			log.Printf("collision: %q and %q differ; skipping\n", path, dstPath)
+			collisions++
+		default:
+			fmt.Printf("renaming: %q to %q\n", path, dstPath)
+			renamed++
+		}
+	}
+
+	// Rename directories, deepest first.
+	var dirs []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if info.IsDir() && path != root {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], string(filepath.Separator)) > strings.Count(dirs[j], string(filepath.Separator))
+	})
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(dir); err != nil {
+				return errors.WithStack(err)
+			}
+			continue
+		}
+		base := filepath.Base(dir)
+		if strings.ToLower(base) == base {
+			continue
+		}
+		dstDir := filepath.Join(filepath.Dir(dir), strings.ToLower(base))
+		if status, err := renameCanonical(dir, dstDir); err != nil {
+			return errors.WithStack(err)
+		} else if status == renameCollision {
+			log.Printf("collision: directory %q not empty after merging into %q; skipping\n", dir, dstDir)
+		}
+	}
+	fmt.Printf("renamed %d file(s), merged %d file(s), %d collision(s)\n", renamed, merged, collisions)
+	return nil
+}
+
+// canonicalPath returns the canonical form of the given output path relative
+// to the output directory; lowercase and slash-separated.
+func canonicalPath(rel string) string {
+	return strings.ToLower(strings.ReplaceAll(filepath.ToSlash(rel), `\`, "/"))
+}
+
+// Outcomes of renameCanonical.
+const (
+	// Source renamed to destination.
+	renameDone = iota
+	// Source removed, as destination is an identical file.
+	renameMerged
+	// Destination taken by a differing file, or a non-empty directory.
+	renameCollision
+)
+
+// renameCanonical renames srcPath to dstPath, which differ at least in case.
+func renameCanonical(srcPath, dstPath string) (int, error) {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	dstInfo, err := os.Stat(dstPath)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.Rename(srcPath, dstPath); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return renameDone, nil
+	case err != nil:
+		return 0, errors.WithStack(err)
+	case os.SameFile(srcInfo, dstInfo):
+		// Case-insensitive file system; rename through temporary name.
+		tmpPath := srcPath + ".normalize-tmp"
+		if err := os.Rename(srcPath, tmpPath); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		if err := os.Rename(tmpPath, dstPath); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return renameDone, nil
+	case srcInfo.IsDir() || dstInfo.IsDir():
+		return renameCollision, nil
+	}
+	src, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	dst, err := ioutil.ReadFile(dstPath)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if !bytes.Equal(src, dst) {
+		return renameCollision, nil
+	}
+	if err := os.Remove(srcPath); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return renameMerged, nil
+}
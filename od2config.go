@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// od2Config is the subset of the OpenDiablo2 configuration file (config.json)
+// used to locate MPQ archives.
+//
+// Note, OpenDiablo2 has no on-disk asset cache which extracted files could
+// prime; Engine.LoadFile (d2core/engine.go of the OpenDiablo2 revision of
+// go.mod) reads each file directly from the MPQ archives of MpqPath in
+// MpqLoadOrder, and caches only the MPQ path of each file name in the
+// in-memory Engine.Files map. The only files OpenDiablo2 writes are save games
+// (d2core/game_state.go). Hence, the MPQ directory and load order are the only
+// engine asset paths of interest.
+type od2Config struct {
+	// Path to Diablo II MPQ directory.
+	MpqPath string
+	// MPQ archive names, in load order.
+	MpqLoadOrder []string
+}
+
+// loadOD2Config loads the OpenDiablo2 configuration file at the given path.
+func loadOD2Config(configPath string) (*od2Config, error) {
+	buf, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	config := &od2Config{}
+	if err := json.Unmarshal(buf, config); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse OpenDiablo2 config %q", configPath)
+	}
+	config.MpqPath = fixWinePath(config.MpqPath)
+	return config, nil
+}
+
+// fixWinePath translates a Windows MPQ path (e.g. as used by OpenDiablo2 for
+// Diablo II installed under Wine on Linux) into the corresponding path of the
+// Wine C: drive, if the path does not exist as is. This mirrors the path fixup
+// of OpenDiablo2.
+func fixWinePath(mpqPath string) string {
+	if len(mpqPath) == 0 || mpqPath[0] == '/' {
+		return mpqPath
+	}
+	if _, err := os.Stat(mpqPath); !os.IsNotExist(err) {
+		return mpqPath
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return mpqPath
+	}
+	newPath := strings.ReplaceAll(mpqPath, `C:\`, homeDir+"/.wine/drive_c/")
+	newPath = strings.ReplaceAll(newPath, "C:/", homeDir+"/.wine/drive_c/")
+	newPath = strings.ReplaceAll(newPath, `\`, "/")
+	if _, err := os.Stat(newPath); err != nil {
+		return mpqPath
+	}
+	return newPath
+}
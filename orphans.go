@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/mewkiz/pkg/pathutil"
+)
+
+// orphanBlocks returns the indices of block table entries of the MPQ archive
+// which are not referenced by any occupied hash table entry. Such blocks are
+// unreachable by file name, but may still contain recoverable data.
+func orphanBlocks(archive *d2mpq.MPQ) []int {
+	referenced := make(map[uint32]bool)
+	for _, entry := range archive.HashTableEntries {
+		if entry.BlockIndex == d2mpq.BlockIndexFree || entry.BlockIndex == d2mpq.BlockIndexDeleted {
+			continue
+		}
+		referenced[entry.BlockIndex] = true
+	}
+	var orphans []int
+	for i, block := range archive.BlockTableEntries {
+		if referenced[uint32(i)] {
+			continue
+		}
+		// Skip unused block table entries.
+		if !block.HasFlag(d2mpq.FileExists) && block.CompressedFileSize == 0 {
+			continue
+		}
+		orphans = append(orphans, i)
+	}
+	return orphans
+}
+
+// fileNameHash returns the name hashes of the given file path.
+func fileNameHash(filePath string) nameHash {
+	_, hashA, hashB := d2mpq.HashFileName(archivePath(filePath))
+	return nameHash{a: hashA, b: hashB}
+}
+
+// fileHashes returns the name hashes of the files of the MPQ archive; i.e. of
+// the occupied hash table entries referencing an existing block.
+func fileHashes(archive *d2mpq.MPQ) []nameHash {
+	var hashes []nameHash
+	for _, entry := range archive.HashTableEntries {
+		if entry.BlockIndex >= uint32(len(archive.BlockTableEntries)) {
+			continue
+		}
+		if !archive.BlockTableEntries[entry.BlockIndex].HasFlag(d2mpq.FileExists) {
+			continue
+		}
+		hashes = append(hashes, nameHash{a: entry.NamePartA, b: entry.NamePartB})
+	}
+	return hashes
+}
+
+// namedHashes returns the set of name hashes of the given file paths, and of the
+// internal files of MPQ archives (e.g. the (listfile)).
+func namedHashes(filePaths []string) map[nameHash]bool {
+	names := make(map[nameHash]bool)
+	internal := []string{"(listfile)", "(attributes)", "(signature)"}
+	for _, filePath := range append(internal, filePaths...) {
+		names[fileNameHash(filePath)] = true
+	}
+	return names
+}
+
+// countNamed returns the number of files of the MPQ archive named by the given
+// file paths; i.e. the number of occupied hash table entries referencing an
+// existing block whose name hashes match any of the file paths. Internal files
+// (e.g. the (listfile)) are always named.
+func countNamed(archive *d2mpq.MPQ, filePaths []string) int {
+	names := namedHashes(filePaths)
+	n := 0
+	for _, hash := range fileHashes(archive) {
+		if names[hash] {
+			n++
+		}
+	}
+	return n
+}
+
+// reportUnnamed prints the number of files of each MPQ archive not named by
+// the given file paths (e.g. of the listfile), out of the total number of
+// files of the archive.
+func reportUnnamed(archives []*d2mpq.MPQ, filePaths []string) {
+	for _, archive := range archives {
+		total := archive.FileCount()
+		named := countNamed(archive, filePaths)
+		fmt.Printf("%d of %d file(s) unnamed in %q\n", total-named, total, pathutil.FileName(archive.FileName))
+	}
+}
+
+// listOrphans prints the block index, file offset, compressed size,
+// uncompressed size and flags of each orphaned block table entry of the MPQ
+// archives.
+func listOrphans(archives []*d2mpq.MPQ) {
+	for _, archive := range archives {
+		archiveName := pathutil.FileName(archive.FileName)
+		orphans := orphanBlocks(archive)
+		fmt.Printf("%d orphaned block(s) in %q\n", len(orphans), archiveName)
+		for _, index := range orphans {
+			block := archive.BlockTableEntries[index]
+			fmt.Printf("%s\tblock %d\toffset 0x%08X\tcompressed %d\tuncompressed %d\tflags 0x%08X\n", archiveName, index, block.Position(), block.CompressedFileSize, block.UncompressedFileSize, uint32(block.Flags))
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/pkg/errors"
+)
+
+// patchMetadataName is the name of the patch metadata file of patch archives.
+const patchMetadataName = "(patch_metadata)"
+
+// patchEntry is a patch-delta file of a patch archive.
+type patchEntry struct {
+	// File path of patch-delta file.
+	filePath string
+	// File path of the base file to which the patch-delta applies.
+	basePath string
+}
+
+// readPatchMetadata returns the patch-delta files listed in the
+// (patch_metadata) file of the MPQ archive, and a boolean indicating whether the
+// MPQ archive contains a (patch_metadata) file.
+//
+// Each non-empty line of (patch_metadata) lists the file path of a patch-delta
+// file, optionally followed by a tab and the file path of its base file; the
+// base file path defaults to the file path of the patch-delta file. Lines
+// starting with '#' are ignored.
+func readPatchMetadata(archive *d2mpq.MPQ) ([]patchEntry, bool, error) {
+	if !archive.FileExists(patchMetadataName) {
+		return nil, false, nil
+	}
+	data, err := archiveReadFile(archive, patchMetadataName)
+	if err != nil {
+		return nil, true, errors.WithStack(err)
+	}
+	var entries []patchEntry
+	s := bufio.NewScanner(bytes.NewReader(bytes.TrimRight(data, "\x00")))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry := patchEntry{filePath: line, basePath: line}
+		if pos := strings.IndexByte(line, '\t'); pos != -1 {
+			entry.filePath = strings.TrimSpace(line[:pos])
+			entry.basePath = strings.TrimSpace(line[pos+1:])
+		}
+		entries = append(entries, entry)
+	}
+	if err := s.Err(); err != nil {
+		return nil, true, errors.WithStack(err)
+	}
+	return entries, true, nil
+}
+
+// patchEntries returns the patch-delta files of the MPQ archive; as listed by
+// its (patch_metadata) file if present, and otherwise the files of its embedded
+// (listfile) flagged as patch files, each patching the file of the same path.
+func patchEntries(archive *d2mpq.MPQ) ([]patchEntry, bool, error) {
+	entries, ok, err := readPatchMetadata(archive)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	if ok {
+		return entries, true, nil
+	}
+	if !archive.FileExists("(listfile)") {
+		return nil, false, nil
+	}
+	files, err := archive.GetFileList()
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	for _, filePath := range files {
+		block, ok := archive.FileBlock(archivePath(denormalize(filePath)))
+		if !ok || !block.HasFlag(d2mpq.FilePatchFile) {
+			continue
+		}
+		entries = append(entries, patchEntry{filePath: filePath, basePath: filePath})
+	}
+	return entries, false, nil
+}
+
+// resolvePatchBase returns the MPQ archive containing the base file of the
+// given patch-delta file of the patch archive; i.e. the first MPQ archive of
+// lower priority than the patch archive (as sorted by sortArchives) containing
+// the base file path as a regular (non-patch) file.
+func resolvePatchBase(archives []*d2mpq.MPQ, patch *d2mpq.MPQ, entry patchEntry) (*d2mpq.MPQ, bool) {
+	basePath := archivePath(denormalize(entry.basePath))
+	below := false
+	for _, archive := range archives {
+		if archive == patch {
+			below = true
+			continue
+		}
+		if !below {
+			continue
+		}
+		block, ok := archive.FileBlock(basePath)
+		if !ok || block.HasFlag(d2mpq.FilePatchFile) {
+			continue
+		}
+		return archive, true
+	}
+	return nil, false
+}
+
+// dumpPatchMetadata prints the patch-delta files of each MPQ archive along with
+// their resolved base files.
+func dumpPatchMetadata(archives []*d2mpq.MPQ, opts options) error {
+	for _, archive := range archives {
+		archiveName := pathutil.FileName(archive.FileName)
+		entries, ok, err := patchEntries(archive)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		source := "patch file flags"
+		if ok {
+			source = patchMetadataName
+		}
+		fmt.Printf("%d patch-delta file(s) in %q (from %s)\n", len(entries), archiveName, source)
+		for _, entry := range entries {
+			base := "(not found)"
+			if baseArchive, ok := resolvePatchBase(archives, archive, entry); ok {
+				base = pathutil.FileName(baseArchive.FileName)
+			}
+			filePath := opts.pathStyle.format(normalize(entry.filePath))
+			basePath := opts.pathStyle.format(normalize(entry.basePath))
+			fmt.Printf("%s\t%s\tbase %s\tin %s\n", archiveName, filePath, basePath, base)
+		}
+	}
+	return nil
+}
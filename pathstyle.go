@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pathStyle specifies the path separator style of file paths recorded in
+// textual outputs (indices, manifests, listings and databases). Paths of files
+// written to disk always use the separator of the host.
+type pathStyle string
+
+// Path separator styles.
+const (
+	// Slash-separated paths.
+	pathStyleUnix pathStyle = "unix"
+	// Backslash-separated paths.
+	pathStyleWindows pathStyle = "windows"
+)
+
+// String returns the string representation of the path style.
+func (style *pathStyle) String() string {
+	if len(*style) == 0 {
+		return string(pathStyleUnix)
+	}
+	return string(*style)
+}
+
+// Set sets the path style; either "unix" or "windows".
+func (style *pathStyle) Set(s string) error {
+	switch pathStyle(strings.ToLower(s)) {
+	case pathStyleUnix:
+		*style = pathStyleUnix
+	case pathStyleWindows:
+		*style = pathStyleWindows
+	default:
+		return errors.Errorf("invalid path style %q; expected unix or windows", s)
+	}
+	return nil
+}
+
+// format returns the given normalized (slash-separated) path using the
+// separators of the path style.
+func (style pathStyle) format(normalizedPath string) string {
+	if style == pathStyleWindows {
+		return strings.ReplaceAll(normalizedPath, "/", `\`)
+	}
+	return normalizedPath
+}
+
+// recordedPath returns the output path of the given file as recorded in
+// textual outputs, using the path style specified by opts.
+func recordedPath(filePath string, opts options) string {
+	return opts.pathStyle.format(outputPath(filePath, opts))
+}
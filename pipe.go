@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/pkg/errors"
+)
+
+// pipeFile pipes the contents of the given file through the external shell
+// command, and returns the output of the command. The file path is provided to
+// the command through the MPQ_FILE environment variable.
+func pipeFile(command, filePath string, data []byte) ([]byte, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "MPQ_FILE="+normalize(filePath))
+	cmd.Stdin = bytes.NewReader(data)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			err = errors.Errorf("%v; %s", err, msg)
+		}
+		return nil, errors.Wrapf(ErrFileRead, "pipe command %q failed for %q; %v", command, filePath, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// replaceExt replaces the extension of the given file path with ext.
+func replaceExt(filePath, ext string) string {
+	if len(ext) > 0 && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return pathutil.TrimExt(filePath) + ext
+}
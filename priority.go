@@ -0,0 +1,134 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/pkg/errors"
+)
+
+// priorityFlags maps from MPQ archive name to assigned priority, implementing
+// flag.Value so that the flag may be repeated.
+type priorityFlags map[string]int
+
+// String returns the string representation of the assigned priorities.
+func (priorities priorityFlags) String() string {
+	var ss []string
+	for name, priority := range priorities {
+		ss = append(ss, name+"="+strconv.Itoa(priority))
+	}
+	sort.Strings(ss)
+	return strings.Join(ss, ",")
+}
+
+// Set assigns the priority of the form "NAME=N", where NAME is the label or the
+// base name (with or without extension) of an MPQ archive.
+func (priorities priorityFlags) Set(s string) error {
+	pos := strings.LastIndex(s, "=")
+	if pos <= 0 {
+		return errors.Errorf("invalid priority %q; expected NAME=N", s)
+	}
+	priority, err := strconv.Atoi(s[pos+1:])
+	if err != nil {
+		return errors.Errorf("invalid priority %q; %v", s, err)
+	}
+	priorities[strings.ToLower(s[:pos])] = priority
+	return nil
+}
+
+// reportPrecedence logs which MPQ archive the given file was read from when
+// the file is present in more than one of the MPQ archives, which are sorted in
+// order of precedence (see sortArchives).
+func reportPrecedence(archives []*d2mpq.MPQ, filePath string, chosen *d2mpq.MPQ, opts options) {
+	var overridden []string
+	for _, archive := range archives {
+		if archive != chosen && archive.FileExists(archivePath(filePath)) {
+			overridden = append(overridden, strconv.Quote(archive.FileName))
+		}
+	}
+	if len(overridden) == 0 {
+		return
+	}
+	kind := ""
+	if opts.patches[chosen] {
+		kind = "patch "
+	}
+	infof("%q found in %d MPQ archives; using %sMPQ archive %q over %s\n", normalize(filePath), len(overridden)+1, kind, chosen.FileName, strings.Join(overridden, ", "))
+}
+
+// patchFlags lists the paths of patch MPQ archives, implementing flag.Value so
+// that the flag may be repeated.
+type patchFlags []string
+
+// String returns the string representation of the patch MPQ archives.
+func (patches *patchFlags) String() string {
+	return strings.Join(*patches, ",")
+}
+
+// Set adds the patch MPQ archive of the form "[LABEL=]FILE.mpq".
+func (patches *patchFlags) Set(s string) error {
+	*patches = append(*patches, s)
+	return nil
+}
+
+// lookup returns the assigned priority of the given MPQ archive, and a boolean
+// indicating whether a priority was assigned.
+func (priorities priorityFlags) lookup(archive *d2mpq.MPQ, opts options) (int, bool) {
+	names := []string{
+		strings.ToLower(filepath.Base(archive.FileName)),
+		strings.ToLower(pathutil.FileName(archive.FileName)),
+	}
+	if label, ok := opts.labels[archive]; ok {
+		names = append([]string{strings.ToLower(label)}, names...)
+	}
+	for _, name := range names {
+		if priority, ok := priorities[name]; ok {
+			return priority, true
+		}
+	}
+	return 0, false
+}
+
+// sortArchives sorts the MPQ archives in the order in which they are searched
+// for files; i.e. by descending priority. sortArchives returns the priority of
+// each MPQ archive.
+//
+// By default, each MPQ archive has a priority based on its position in the
+// load order, where the first loaded MPQ archive has the highest priority. The
+// default priority of the MPQ archive at position i (zero-based) of n loaded
+// MPQ archives is n-1-i, and may be overridden with -priority. MPQ archives of
+// equal priority are searched in order of archive name (base name, then full
+// path), which keeps file resolution deterministic regardless of load order.
+//
+// Patch MPQ archives (opts.patches) override all other MPQ archives, and
+// later loaded patch MPQ archives override earlier ones; the default priority
+// of the patch MPQ archive at position i is n+i.
+func sortArchives(archives []*d2mpq.MPQ, priorities priorityFlags, opts options) map[*d2mpq.MPQ]int {
+	prio := make(map[*d2mpq.MPQ]int)
+	for i, archive := range archives {
+		priority, ok := priorities.lookup(archive, opts)
+		if !ok {
+			priority = len(archives) - 1 - i
+			if opts.patches[archive] {
+				priority = len(archives) + i
+			}
+		}
+		prio[archive] = priority
+	}
+	sort.SliceStable(archives, func(i, j int) bool {
+		a, b := archives[i], archives[j]
+		if prio[a] != prio[b] {
+			return prio[a] > prio[b]
+		}
+		nameA, nameB := strings.ToLower(filepath.Base(a.FileName)), strings.ToLower(filepath.Base(b.FileName))
+		if nameA != nameB {
+			return nameA < nameB
+		}
+		return a.FileName < b.FileName
+	})
+	return prio
+}
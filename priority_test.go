@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/OpenDiablo2/MpqViewer/internal/mpqtest"
+)
+
+func TestSortArchivesTieBreak(t *testing.T) {
+	const booksPath = `data\global\excel\books.txt`
+	archive := func(name string) testArchive {
+		return testArchive{name: name, Archive: mpqtest.Archive{Files: []mpqtest.File{{Name: booksPath, Data: []byte(name), Compression: mpqtest.CompressionZlib}}}}
+	}
+	golden := []struct {
+		name       string
+		loadOrder  []string
+		priorities priorityFlags
+		// Name of the MPQ archive expected to provide the shared file.
+		want string
+	}{
+		{name: "load order", loadOrder: []string{"patch_b.mpq", "patch_a.mpq"}, priorities: priorityFlags{}, want: "patch_b.mpq"},
+		{name: "load order reversed", loadOrder: []string{"patch_a.mpq", "patch_b.mpq"}, priorities: priorityFlags{}, want: "patch_a.mpq"},
+		{name: "equal priority", loadOrder: []string{"patch_b.mpq", "patch_a.mpq"}, priorities: priorityFlags{"patch_a": 1, "patch_b": 1}, want: "patch_a.mpq"},
+		{name: "equal priority reversed", loadOrder: []string{"patch_a.mpq", "patch_b.mpq"}, priorities: priorityFlags{"patch_a": 1, "patch_b": 1}, want: "patch_a.mpq"},
+		{name: "higher priority", loadOrder: []string{"patch_a.mpq", "patch_b.mpq"}, priorities: priorityFlags{"patch_a": 1, "patch_b": 2}, want: "patch_b.mpq"},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			var archives []testArchive
+			for _, name := range g.loadOrder {
+				archives = append(archives, archive(name))
+			}
+			loaded := loadTestArchives(t, t.TempDir(), archives...)
+			sortArchives(loaded, g.priorities, options{})
+			data, _, err := readFile(loaded, booksPath, options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, []byte(g.want)) {
+				t.Errorf("file read from wrong MPQ archive; expected %q, got %q", g.want, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// rewriteRule is a prefix rewrite rule of output file paths.
+type rewriteRule struct {
+	// Path prefix to replace.
+	from string
+	// Replacement path prefix.
+	to string
+}
+
+// rewriteRules is a list of prefix rewrite rules of the form "from=to",
+// implementing flag.Value so that the flag may be repeated.
+type rewriteRules []rewriteRule
+
+// String returns the string representation of the rewrite rules.
+func (rules *rewriteRules) String() string {
+	var ss []string
+	for _, rule := range *rules {
+		ss = append(ss, rule.from+"="+rule.to)
+	}
+	return strings.Join(ss, ",")
+}
+
+// Set adds the rewrite rule of the form "from=to".
+func (rules *rewriteRules) Set(s string) error {
+	pos := strings.Index(s, "=")
+	if pos == -1 {
+		return errors.Errorf("invalid rewrite rule %q; expected from=to", s)
+	}
+	rule := rewriteRule{
+		from: strings.Trim(normalize(s[:pos]), "/"),
+		to:   strings.Trim(normalize(s[pos+1:]), "/"),
+	}
+	if len(rule.from) == 0 {
+		return errors.Errorf("invalid rewrite rule %q; empty path prefix", s)
+	}
+	*rules = append(*rules, rule)
+	return nil
+}
+
+// apply applies the rewrite rules in order to the given normalized file path.
+// Path prefixes are matched case-insensitively on whole path components, and
+// file paths not matching a rule are left unchanged by it.
+func (rules rewriteRules) apply(filePath string) string {
+	for _, rule := range rules {
+		trimmed := strings.TrimPrefix(filePath, "/")
+		if len(trimmed) < len(rule.from) || !strings.EqualFold(trimmed[:len(rule.from)], rule.from) {
+			continue
+		}
+		rest := trimmed[len(rule.from):]
+		if len(rest) > 0 && rest[0] != '/' {
+			continue
+		}
+		if len(rule.to) == 0 {
+			rest = strings.TrimPrefix(rest, "/")
+		}
+		filePath = rule.to + rest
+	}
+	return filePath
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// sampleFiles returns n file paths selected at random from the given file
+// paths, using the given seed. The sampled file paths are returned in their
+// original order. If n is at least the number of file paths, all file paths are
+// returned.
+func sampleFiles(filePaths []string, n int, seed int64) []string {
+	if n >= len(filePaths) {
+		return filePaths
+	}
+	r := rand.New(rand.NewSource(seed))
+	indices := r.Perm(len(filePaths))[:n]
+	sort.Ints(indices)
+	sample := make([]string, 0, n)
+	for _, i := range indices {
+		sample = append(sample, filePaths[i])
+	}
+	return sample
+}
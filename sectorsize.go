@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+)
+
+// maxSectorShift is the largest valid sector size shift of MPQ archives; i.e.
+// a sector size of 64 KiB.
+const maxSectorShift = 7
+
+// maxSectorProbes is the maximum number of blocks probed for each candidate
+// sector size.
+const maxSectorProbes = 64
+
+// detectSectorSize returns the sector size shift (sector size = 512 << shift)
+// of the MPQ archive, as determined by probing the sector offset tables of its
+// blocks for each candidate sector size from 512 bytes to 64 KiB. The candidate
+// yielding the largest number of well-formed sector offset tables is returned,
+// preferring the declared sector size on ties. The boolean result reports
+// whether any block could be probed.
+func detectSectorSize(archive *d2mpq.MPQ) (uint16, bool) {
+	declared := archive.Data.BlockSize
+	best, bestScore := declared, -1
+	if declared <= maxSectorShift {
+		bestScore = sectorSizeScore(archive, declared)
+	}
+	probed := bestScore > 0
+	for shift := uint16(0); shift <= maxSectorShift; shift++ {
+		if shift == declared {
+			continue
+		}
+		score := sectorSizeScore(archive, shift)
+		if score > 0 {
+			probed = true
+		}
+		if score > bestScore {
+			best, bestScore = shift, score
+		}
+	}
+	return best, probed
+}
+
+// sectorSizeScore returns the number of probed blocks of the MPQ archive with a
+// well-formed sector offset table for the given sector size shift; i.e. an
+// offset table that is monotonic, within the bounds of the block and with no
+// sector larger than the sector size.
+//
+// Only blocks of compressed, unencrypted, multi-sector files are probed, as the
+// sector offset tables of encrypted files cannot be decrypted without knowing
+// their file names.
+func sectorSizeScore(archive *d2mpq.MPQ, shift uint16) int {
+	sectorSize := uint32(0x200) << shift
+	score, probes := 0, 0
+	for _, block := range archive.BlockTableEntries {
+		if probes >= maxSectorProbes {
+			break
+		}
+		switch {
+		case !block.HasFlag(d2mpq.FileExists):
+			continue
+		case !block.HasFlag(d2mpq.FileCompress) && !block.HasFlag(d2mpq.FileImplode):
+			continue
+		case block.HasFlag(d2mpq.FileSingleUnit), block.HasFlag(d2mpq.FileEncrypted):
+			continue
+		case block.UncompressedFileSize == 0:
+			continue
+		}
+		probes++
+		nsectors := (block.UncompressedFileSize + sectorSize - 1) / sectorSize
+		n := nsectors + 1
+		if block.HasFlag(d2mpq.FileSectorCrc) {
+			n++
+		}
+		if n*4 > block.CompressedFileSize {
+			continue
+		}
+		buf := make([]byte, n*4)
+		if _, err := archive.File.ReadAt(buf, block.Position()); err != nil {
+			continue
+		}
+		if validSectorOffsets(buf, nsectors, sectorSize, block.CompressedFileSize) {
+			score++
+		}
+	}
+	return score
+}
+
+// validSectorOffsets reports whether the given raw sector offset table is
+// well-formed for a block of the given compressed size, containing nsectors
+// sectors of at most sectorSize bytes.
+func validSectorOffsets(buf []byte, nsectors, sectorSize, compressedSize uint32) bool {
+	n := uint32(len(buf) / 4)
+	prev := binary.LittleEndian.Uint32(buf[0:4])
+	if prev != n*4 {
+		return false
+	}
+	for i := uint32(1); i <= nsectors; i++ {
+		offset := binary.LittleEndian.Uint32(buf[i*4:])
+		if offset <= prev || offset-prev > sectorSize || offset > compressedSize {
+			return false
+		}
+		prev = offset
+	}
+	return true
+}
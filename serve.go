@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// server serves the contents of files in MPQ archives over HTTP.
+type server struct {
+	// MPQ archives to serve files from.
+	archives []*d2mpq.MPQ
+	// Extraction options.
+	opts options
+	// Semaphore bounding the number of simultaneous file reads.
+	sem chan struct{}
+	// Per-archive locks serializing reads from each MPQ archive, as d2mpq.MPQ
+	// is not safe for concurrent use; reads from distinct MPQ archives proceed
+	// concurrently.
+	locks archiveLocks
+}
+
+// serve serves the contents of files in the MPQ archives over HTTP on the
+// given address; e.g. GET /data/global/excel/books.txt.
+//
+// At most maxConcurrency file reads are in flight at any time, so that bursts
+// of requests may not exhaust memory by decompressing many large files at once;
+// requests beyond the limit are queued. Requests not served within timeout are
+// aborted, releasing their place in the queue.
+func serve(addr string, archives []*d2mpq.MPQ, maxConcurrency int, timeout time.Duration, opts options) error {
+	srv, err := newServer(archives, maxConcurrency, opts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var handler http.Handler = srv
+	if timeout > 0 {
+		handler = http.TimeoutHandler(srv, timeout, "request timed out")
+	}
+	log.Printf("serving MPQ archives on %q\n", addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// newServer returns a server of the files in the given MPQ archives, with at
+// most maxConcurrency file reads in flight at any time.
+//
+// The unbounded per-archive file cache of d2mpq is disabled, as it would grow
+// with every file served; files are instead cached by the bounded LRU cache of
+// opts.cache, if any.
+func newServer(archives []*d2mpq.MPQ, maxConcurrency int, opts options) (*server, error) {
+	if maxConcurrency < 1 {
+		return nil, errors.Errorf("invalid maximum concurrency %d; must be at least 1", maxConcurrency)
+	}
+	srv := &server{
+		archives: archives,
+		opts:     opts,
+		sem:      make(chan struct{}, maxConcurrency),
+		locks:    newArchiveLocks(archives),
+	}
+	for _, archive := range archives {
+		archive.DisableFileCache()
+	}
+	return srv, nil
+}
+
+// ServeHTTP serves the file at the request path.
+func (srv *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	filePath := path.Clean(r.URL.Path)
+	if filePath == "/" {
+		http.Error(w, "no file specified", http.StatusNotFound)
+		return
+	}
+	// Wait for a free read slot.
+	select {
+	case srv.sem <- struct{}{}:
+		defer func() { <-srv.sem }()
+	case <-r.Context().Done():
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	// Wait for the MPQ archive containing the file.
+	archive, ok := findArchive(srv.archives, filePath)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := srv.locks.lockContext(r.Context(), archive); err != nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	data, _, err := readFile(srv.archives, filePath, srv.opts)
+	srv.locks.unlock(archive)
+	if err != nil {
+		switch errors.Cause(err) {
+		case ErrNotFound:
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		default:
+			log.Printf("file read error %q; %+v\n", filePath, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+	http.ServeContent(w, r, path.Base(filePath), time.Time{}, bytes.NewReader(data))
+}
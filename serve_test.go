@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OpenDiablo2/MpqViewer/internal/mpqtest"
+)
+
+func TestServeLocks(t *testing.T) {
+	archives := loadTestArchives(t, t.TempDir(),
+		testArchive{name: "d2data.mpq", Archive: mpqtest.Archive{Files: []mpqtest.File{{Name: `data\global\excel\books.txt`, Data: books, Compression: mpqtest.CompressionZlib}}}},
+		testArchive{name: "d2exp.mpq", Archive: mpqtest.Archive{Files: []mpqtest.File{{Name: `data\global\excel\charstats.txt`, Data: charstats, Compression: mpqtest.CompressionZlib}}}},
+	)
+	// A single read slot.
+	srv, err := newServer(archives, 1, options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests are served in order; d2data.mpq is locked as if by a pending
+	// read while locked is set.
+	golden := []struct {
+		name   string
+		path   string
+		locked bool
+		status int
+		want   []byte
+	}{
+		// Waiting for the lock is abandoned on timeout, releasing the read slot.
+		{name: "locked archive", path: "/data/global/excel/books.txt", locked: true, status: http.StatusServiceUnavailable},
+		// Reads from other archives proceed, in the read slot released above.
+		{name: "other archive", path: "/data/global/excel/charstats.txt", locked: true, status: http.StatusOK, want: charstats},
+		{name: "unlocked archive", path: "/data/global/excel/books.txt", locked: false, status: http.StatusOK, want: books},
+		{name: "not found", path: "/data/global/excel/missing.txt", locked: false, status: http.StatusNotFound},
+	}
+	for _, g := range golden {
+		if g.locked {
+			srv.locks.lock(archives[0])
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		req := httptest.NewRequest(http.MethodGet, g.path, nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		cancel()
+		if g.locked {
+			srv.locks.unlock(archives[0])
+		}
+		if rec.Code != g.status {
+			t.Errorf("%s: status mismatch; expected %d, got %d", g.name, g.status, rec.Code)
+			continue
+		}
+		if g.want != nil && !bytes.Equal(rec.Body.Bytes(), g.want) {
+			t.Errorf("%s: contents mismatch; expected %d bytes, got %d bytes", g.name, len(g.want), rec.Body.Len())
+		}
+	}
+}
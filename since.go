@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// parseSince parses the cutoff time of -since, as either a date (e.g.
+// "2021-01-01") or an RFC 3339 timestamp (e.g. "2021-01-01T12:00:00Z"). Dates
+// are interpreted in UTC.
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time %q of -since; expected YYYY-MM-DD or RFC 3339 timestamp", s)
+	}
+	return t, nil
+}
+
+// filterSince returns the file paths modified at or after the given cutoff
+// time, as recorded in the (attributes) file of the MPQ archives containing
+// the files. Files are kept if any MPQ archive containing the file has no
+// recorded modification time of the file; MPQ archives without modification
+// times are reported once.
+func filterSince(archives []*d2mpq.MPQ, filePaths []string, since time.Time) ([]string, error) {
+	noTimes := make(map[*d2mpq.MPQ]bool)
+	var kept []string
+	for _, filePath := range filePaths {
+		keep := false
+		var latest time.Time
+		for _, archive := range archives {
+			if !archive.FileExists(archivePath(filePath)) {
+				continue
+			}
+			modTime, err := archive.FileTime(archivePath(filePath))
+			if err != nil {
+				if errors.Cause(err) != d2mpq.ErrNoAttributes {
+					return nil, errors.WithStack(err)
+				}
+				if !noTimes[archive] {
+					log.Printf("MPQ archive %q has no file times in (attributes); -since does not apply to its files\n", archive.FileName)
+					noTimes[archive] = true
+				}
+				keep = true
+				break
+			}
+			if modTime.IsZero() || !modTime.Before(since) {
+				keep = true
+				break
+			}
+			if modTime.After(latest) {
+				latest = modTime
+			}
+		}
+		if !keep && !latest.IsZero() {
+			infof("skipping %q; modified %s, before %s\n", normalize(filePath), latest.Format(time.RFC3339), since.Format(time.RFC3339))
+			continue
+		}
+		kept = append(kept, filePath)
+	}
+	return kept, nil
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Sink is a destination of extracted files.
+type Sink interface {
+	// WriteFile writes the contents of the given file, extracted from the MPQ
+	// archive with the given output directory name. The file path is
+	// normalized; i.e. slash-separated. ErrSkipped is returned if the file
+	// is skipped rather than written.
+	WriteFile(archiveDir, filePath string, data []byte) error
+	// Close flushes any pending writes and closes the sink.
+	Close() error
+}
+
+// streamSink is a sink to which extracted files may be streamed, rather than
+// written from memory.
+type streamSink interface {
+	Sink
+	// WriteStream writes the contents read from r as the given file, as
+	// WriteFile does, and returns the number of bytes read from r.
+	WriteStream(archiveDir, filePath string, r io.Reader) (int64, error)
+}
+
+// dirSink writes extracted files to a directory tree on disk.
+type dirSink struct {
+	// Root directory of output files.
+	root string
+	// Write "<file>.sha256" sidecar file next to each extracted file.
+	sha256Sidecar bool
+	// Report output files without writing them.
+	dryRun bool
+	// Make read-only destination files writable before overwriting them.
+	force bool
+	// Skip files whose output path already exists rather than overwriting
+	// them.
+	noClobber bool
+	// Number of files written (or reported, in dry runs); updated atomically.
+	files int64
+	// Output paths claimed in flat mode, where the output directory of MPQ
+	// archives is omitted from output paths; nil if not flat.
+	flat *flatClaims
+}
+
+// WriteFile writes the contents of the given file to
+// root/archiveDir/filePath, or to root/filePath in flat mode.
+func (sink *dirSink) WriteFile(archiveDir, filePath string, data []byte) error {
+	if sink.clobbers(archiveDir, filePath) {
+		return ErrSkipped
+	}
+	if sink.flat != nil {
+		if !sink.flat.claim(archiveDir, filePath) {
+			return ErrSkipped
+		}
+		archiveDir = ""
+	}
+	dstPath := normalize(filepath.Join(sink.root, archiveDir, filePath))
+	infof("creating: %q\n", dstPath)
+	atomic.AddInt64(&sink.files, 1)
+	if sink.dryRun {
+		return nil
+	}
+	dir := filepath.Dir(dstPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := sink.writeFile(dstPath, data); err != nil {
+		return errors.WithStack(err)
+	}
+	if sink.sha256Sidecar {
+		if err := writeSHA256Sidecar(dstPath, data); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// clobbers reports whether writing the given file would overwrite an existing
+// file when noClobber is set, in which case the file is skipped and logged.
+func (sink *dirSink) clobbers(archiveDir, filePath string) bool {
+	if !sink.noClobber {
+		return false
+	}
+	if sink.flat != nil {
+		archiveDir = ""
+	}
+	dstPath := normalize(filepath.Join(sink.root, archiveDir, filePath))
+	if _, err := os.Lstat(dstPath); err != nil {
+		return false
+	}
+	log.Printf("skipping %q (exists)\n", dstPath)
+	return true
+}
+
+// upToDate reports whether the output file of the given file already exists
+// with the given size, in which case extraction is resumed past the file and
+// the file is skipped.
+func (sink *dirSink) upToDate(archiveDir, filePath string, size uint32) bool {
+	if sink.flat != nil {
+		archiveDir = ""
+	}
+	dstPath := normalize(filepath.Join(sink.root, archiveDir, filePath))
+	fi, err := os.Stat(dstPath)
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() != int64(size) {
+		return false
+	}
+	infof("resuming %q (up to date)\n", dstPath)
+	return true
+}
+
+// writtenPath returns the path of the file written to root/archiveDir/filePath,
+// or to root/filePath in flat mode, and a boolean indicating whether the file
+// was written from the MPQ archive of the given output directory name; in flat
+// mode, the output path may have been claimed by another MPQ archive.
+func (sink *dirSink) writtenPath(archiveDir, filePath string) (string, bool) {
+	if sink.flat != nil {
+		if !sink.flat.claimed(archiveDir, filePath) {
+			return "", false
+		}
+		archiveDir = ""
+	}
+	return normalize(filepath.Join(sink.root, archiveDir, filePath)), true
+}
+
+// flatClaims tracks the output paths of files extracted in flat mode, to
+// resolve collisions between files of the same path in multiple MPQ archives.
+type flatClaims struct {
+	// Overwrite files already extracted from an earlier MPQ archive rather
+	// than skipping them.
+	overwrite bool
+	// Maps from output path to the output directory name of the MPQ archive
+	// the file was extracted from.
+	mu      sync.Mutex
+	written map[string]string
+}
+
+// newFlatClaims returns the output paths claimed in flat mode, or nil if
+// opts.flat is not set.
+func newFlatClaims(opts options) *flatClaims {
+	if !opts.flat {
+		return nil
+	}
+	return &flatClaims{overwrite: opts.flatOverwrite, written: make(map[string]string)}
+}
+
+// WriteStream writes the contents read from r to root/archiveDir/filePath, or
+// to root/filePath in flat mode, and returns the number of bytes read from r.
+func (sink *dirSink) WriteStream(archiveDir, filePath string, r io.Reader) (int64, error) {
+	if sink.clobbers(archiveDir, filePath) {
+		return 0, ErrSkipped
+	}
+	if sink.flat != nil {
+		if !sink.flat.claim(archiveDir, filePath) {
+			n, err := io.Copy(ioutil.Discard, r)
+			if err != nil {
+				return n, errors.WithStack(err)
+			}
+			return n, ErrSkipped
+		}
+		archiveDir = ""
+	}
+	dstPath := normalize(filepath.Join(sink.root, archiveDir, filePath))
+	infof("creating: %q\n", dstPath)
+	atomic.AddInt64(&sink.files, 1)
+	if sink.dryRun {
+		n, err := io.Copy(ioutil.Discard, r)
+		return n, errors.WithStack(err)
+	}
+	dir := filepath.Dir(dstPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	f, restore, err := sink.createFile(dstPath)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer f.Close()
+	defer restore()
+	h := sha256.New()
+	w := io.Writer(f)
+	if sink.sha256Sidecar {
+		w = io.MultiWriter(f, h)
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		// Remove the partially written file.
+		f.Close()
+		os.Remove(dstPath)
+		return n, errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return n, errors.WithStack(err)
+	}
+	if err := restore(); err != nil {
+		return n, errors.WithStack(err)
+	}
+	if sink.sha256Sidecar {
+		if err := writeSHA256Sum(dstPath, h.Sum(nil)); err != nil {
+			return n, errors.WithStack(err)
+		}
+	}
+	return n, nil
+}
+
+// claim records that the given file is extracted from the MPQ archive with the
+// given output directory name in flat mode, and reports whether the file should
+// be written; i.e. if not already extracted from an earlier MPQ archive, or if
+// -flat-overwrite is set. Resolved collisions are logged.
+func (c *flatClaims) claim(archiveDir, filePath string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, ok := c.written[filePath]
+	if !ok {
+		c.written[filePath] = archiveDir
+		return true
+	}
+	if !c.overwrite {
+		log.Printf("skipping %q of %q; already extracted from %q\n", filePath, archiveDir, prev)
+		return false
+	}
+	log.Printf("overwriting %q of %q with %q\n", filePath, prev, archiveDir)
+	c.written[filePath] = archiveDir
+	return true
+}
+
+// claimed reports whether the given file was last claimed by the MPQ archive
+// with the given output directory name.
+func (c *flatClaims) claimed(archiveDir, filePath string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.written[filePath] == archiveDir
+}
+
+// writeFile writes the contents of the given file to dstPath.
+func (sink *dirSink) writeFile(dstPath string, data []byte) error {
+	f, restore, err := sink.createFile(dstPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer restore()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(restore())
+}
+
+// createFile creates or truncates the file at dstPath for writing, and returns
+// a function restoring the original mode of the file, to be invoked once the
+// file has been written. If dstPath is a read-only file and force is set, the
+// owner-write permission is added to the mode of the file and the creation is
+// retried.
+func (sink *dirSink) createFile(dstPath string) (f *os.File, restore func() error, err error) {
+	const flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	nop := func() error { return nil }
+	f, err = os.OpenFile(dstPath, flags, 0644)
+	if err == nil {
+		return f, nop, nil
+	}
+	if !os.IsPermission(err) {
+		return nil, nil, errors.WithStack(err)
+	}
+	if !sink.force {
+		return nil, nil, errors.Wrapf(err, "unable to write %q; use -force to overwrite read-only files", dstPath)
+	}
+	fi, err := os.Stat(dstPath)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	mode := fi.Mode().Perm()
+	infof("making %q writable\n", dstPath)
+	if err := os.Chmod(dstPath, mode|0200); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	restored := false
+	restore = func() error {
+		if restored {
+			return nil
+		}
+		restored = true
+		return os.Chmod(dstPath, mode)
+	}
+	f, err = os.OpenFile(dstPath, flags, 0644)
+	if err != nil {
+		restore()
+		return nil, nil, errors.WithStack(err)
+	}
+	return f, restore, nil
+}
+
+// writeSHA256Sidecar writes the SHA-256 hash of the given file contents to
+// "<file>.sha256", in the format of sha256sum so that the file may later be
+// verified using "sha256sum -c".
+func writeSHA256Sidecar(dstPath string, data []byte) error {
+	sum := sha256.Sum256(data)
+	return writeSHA256Sum(dstPath, sum[:])
+}
+
+// writeSHA256Sum writes the given SHA-256 hash of the file at dstPath to
+// "<file>.sha256", in the format of sha256sum.
+func writeSHA256Sum(dstPath string, sum []byte) error {
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum), filepath.Base(dstPath))
+	if err := ioutil.WriteFile(dstPath+".sha256", []byte(line), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Close is a no-op for directory sinks.
+func (sink *dirSink) Close() error {
+	return nil
+}
+
+// newSink returns a new sink of extracted files; a SQLite database if sqlitePath
+// is specified, a tar or zip archive if opts.tarPath or opts.zipPath is
+// specified, and the root directory otherwise. When opts.sha256Sidecar is set, a
+// SHA-256 sidecar file is written next to each file extracted to the root
+// directory. When opts.flat is set, the output directory of MPQ archives is
+// omitted from output paths. When opts.dryRun is set, no files are written; the
+// returned sink reports the output path of each file below the root directory.
+func newSink(root, sqlitePath string, opts options) (Sink, error) {
+	if opts.dryRun {
+		return &dirSink{root: root, dryRun: true, noClobber: opts.existing == existingNoClobber, flat: newFlatClaims(opts)}, nil
+	}
+	if len(sqlitePath) > 0 {
+		logIgnoredFlags(fmt.Sprintf("SQLite database %q", sqlitePath), opts, true)
+		sink, err := newSQLiteSink(sqlitePath, opts.pathStyle)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return sink, nil
+	}
+	if len(opts.tarPath) > 0 {
+		logIgnoredFlags(fmt.Sprintf("tar archive %q", opts.tarPath), opts, false)
+		sink, err := newTarSink(opts.tarPath, opts)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return sink, nil
+	}
+	if len(opts.zipPath) > 0 {
+		logIgnoredFlags(fmt.Sprintf("zip archive %q", opts.zipPath), opts, false)
+		sink, err := newZipSink(opts.zipPath, opts)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return sink, nil
+	}
+	sink := &dirSink{
+		root:          root,
+		sha256Sidecar: opts.sha256Sidecar,
+		force:         opts.force,
+		noClobber:     opts.existing == existingNoClobber,
+		flat:          newFlatClaims(opts),
+	}
+	return sink, nil
+}
+
+// logIgnoredFlags logs the flags set in opts which only apply when extracting
+// files to the root directory, as ignored when storing files in the given
+// destination; e.g. `tar archive "d2data.tar"`. -flat is included if flat is
+// set.
+func logIgnoredFlags(dest string, opts options, flat bool) {
+	ignored := []struct {
+		name string
+		set  bool
+	}{
+		{"-with-sha256-sidecar", opts.sha256Sidecar},
+		{"-no-clobber", opts.existing == existingNoClobber},
+		{"-resume-extract", opts.existing == existingResume},
+		{"-preserve-times", opts.modTimes != nil},
+		{"-flat", flat && opts.flat},
+	}
+	for _, f := range ignored {
+		if f.set {
+			log.Printf("ignoring %s when storing files in %s\n", f.name, dest)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirSinkReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("file permissions are not enforced for root")
+	}
+	golden := []struct {
+		name  string
+		mode  os.FileMode
+		force bool
+		// Substring of the expected error; empty if none.
+		err string
+	}{
+		{name: "writable", mode: 0640, force: false},
+		{name: "read-only", mode: 0444, force: false, err: "use -force"},
+		{name: "read-only forced", mode: 0444, force: true},
+		{name: "group-writable read-only forced", mode: 0460, force: true},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dstPath := filepath.Join(dir, "d2data", "foo.txt")
+			if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(dstPath, []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(dstPath, g.mode); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chmod(dstPath, 0644)
+			sink := &dirSink{root: dir, force: g.force}
+			err := sink.WriteFile("d2data", "foo.txt", []byte("new"))
+			want := "new"
+			switch {
+			case g.err == "" && err != nil:
+				t.Fatalf("unexpected error; %+v", err)
+			case g.err != "" && err == nil:
+				t.Fatalf("expected error containing %q, got nil", g.err)
+			case g.err != "":
+				if !strings.Contains(err.Error(), g.err) {
+					t.Errorf("error mismatch; expected %q, got %q", g.err, err)
+				}
+				want = "old"
+			}
+			got, err := ioutil.ReadFile(dstPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("contents mismatch; expected %q, got %q", want, got)
+			}
+			fi, err := os.Stat(dstPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != g.mode {
+				t.Errorf("mode mismatch; expected %v, got %v", g.mode, fi.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestLogIgnoredFlags(t *testing.T) {
+	golden := []struct {
+		name string
+		opts options
+		flat bool
+		want []string
+	}{
+		{name: "none", opts: options{}, want: nil},
+		{name: "no-clobber", opts: options{existing: existingNoClobber}, want: []string{`ignoring -no-clobber when storing files in tar archive "d2.tar"`}},
+		{name: "flat supported", opts: options{flat: true}, flat: false, want: nil},
+		{name: "flat ignored", opts: options{flat: true, modTimes: &timePreserver{}}, flat: true, want: []string{
+			`ignoring -preserve-times when storing files in tar archive "d2.tar"`,
+			`ignoring -flat when storing files in tar archive "d2.tar"`,
+		}},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			log.SetOutput(buf)
+			log.SetFlags(0)
+			defer func() {
+				log.SetOutput(os.Stderr)
+				log.SetFlags(log.LstdFlags)
+			}()
+			logIgnoredFlags(`tar archive "d2.tar"`, g.opts, g.flat)
+			var got []string
+			if buf.Len() > 0 {
+				got = strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			}
+			if strings.Join(got, "\n") != strings.Join(g.want, "\n") {
+				t.Errorf("log mismatch; expected %q, got %q", g.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// sizeRange is the range of uncompressed file sizes of -min-size and
+// -max-size, in bytes.
+type sizeRange struct {
+	min, max int64
+}
+
+// parseSizeRange parses the lower and upper bounds of -min-size and -max-size;
+// an empty bound is unbounded.
+func parseSizeRange(rawMin, rawMax string) (sizeRange, error) {
+	r := sizeRange{min: 0, max: math.MaxInt64}
+	if len(rawMin) > 0 {
+		n, err := parseSize(rawMin)
+		if err != nil {
+			return sizeRange{}, errors.Wrap(err, "invalid size of -min-size")
+		}
+		r.min = n
+	}
+	if len(rawMax) > 0 {
+		n, err := parseSize(rawMax)
+		if err != nil {
+			return sizeRange{}, errors.Wrap(err, "invalid size of -max-size")
+		}
+		r.max = n
+	}
+	if r.min > r.max {
+		return sizeRange{}, errors.Errorf("-min-size %d exceeds -max-size %d", r.min, r.max)
+	}
+	return r, nil
+}
+
+// parseSize parses the given size in bytes, optionally suffixed by K, M or G
+// (case-insensitive, multiples of 1024) and an optional B; e.g. "500K" or
+// "1MB".
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	unit := int64(1)
+	if len(num) > 0 {
+		switch num[len(num)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		}
+		if unit > 1 {
+			num = num[:len(num)-1]
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/unit {
+		return 0, errors.Errorf("invalid size %q; expected a number of bytes, optionally suffixed by K, M or G", s)
+	}
+	return n * unit, nil
+}
+
+// filter returns the file paths whose uncompressed size, as recorded in the
+// block table of the first MPQ archive containing the file, is within the size
+// range. Skipped files are reported along with the violated bound; files not
+// found in any MPQ archive are kept, to be reported during extraction.
+func (r sizeRange) filter(archives []*d2mpq.MPQ, filePaths []string) []string {
+	var kept []string
+	for _, filePath := range filePaths {
+		archive, ok := findArchive(archives, filePath)
+		if !ok {
+			kept = append(kept, filePath)
+			continue
+		}
+		size, _ := archive.FileSize(archivePath(filePath))
+		switch {
+		case int64(size) < r.min:
+			infof("skipping %q; size %d bytes below -min-size %d bytes\n", normalize(filePath), size, r.min)
+		case int64(size) > r.max:
+			infof("skipping %q; size %d bytes above -max-size %d bytes\n", normalize(filePath), size, r.max)
+		default:
+			kept = append(kept, filePath)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+)
+
+// skipExisting reports whether the given file is skipped by -no-clobber, as
+// its output path below the output directory already exists, or by
+// -resume-extract, as its output file is already up to date. The file is
+// checked before it is read, so that skipped files are not decompressed.
+func skipExisting(archives []*d2mpq.MPQ, filePath string, sink Sink, opts options) bool {
+	if opts.existing == existingOverwrite {
+		return false
+	}
+	s, ok := sink.(*dirSink)
+	if !ok {
+		return false
+	}
+	archive, ok := findArchive(archives, filePath)
+	if !ok {
+		return false
+	}
+	dir := archiveDir(archive, opts)
+	if opts.lower {
+		dir = strings.ToLower(dir)
+	}
+	dstPath := outputPath(filePath, opts)
+	if len(opts.pipeCmd) > 0 && len(opts.pipeExt) > 0 {
+		dstPath = replaceExt(dstPath, opts.pipeExt)
+	}
+	if opts.existing == existingNoClobber {
+		return s.clobbers(dir, dstPath)
+	}
+	// The size of piped output is unrelated to the size of the file.
+	if len(opts.pipeCmd) > 0 {
+		return false
+	}
+	size, ok := archive.FileSize(archivePath(filePath))
+	if !ok {
+		return false
+	}
+	return s.upToDate(dir, dstPath, size)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// splitters maps from file type (see detectType) to the function splitting
+// files of the type into sub-elements, as supported by -split-frames.
+var splitters = map[string]func(data []byte) ([][]byte, error){
+	"dc6": splitDC6,
+}
+
+// splitTypes returns the comma-separated list of file types supported by
+// -split-frames.
+func splitTypes() string {
+	var types []string
+	for fileType := range splitters {
+		types = append(types, fileType)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
+
+// parseSplitTypes parses the given comma-separated list of file types to split.
+func parseSplitTypes(s string) (map[string]bool, error) {
+	types := make(map[string]bool)
+	for _, fileType := range strings.Split(s, ",") {
+		fileType = strings.ToLower(strings.TrimSpace(fileType))
+		if _, ok := splitters[fileType]; !ok {
+			return nil, errors.Errorf("invalid file type %q of -split-frames; expected one of %s", fileType, splitTypes())
+		}
+		types[fileType] = true
+	}
+	return types, nil
+}
+
+// splitPath returns the output path of the sub-element with the given index of
+// the file at dstPath; e.g. "foo.000.dc6".
+func splitPath(dstPath string, index int) string {
+	ext := path.Ext(dstPath)
+	return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(dstPath, ext), index, ext)
+}
+
+// DC6 header and frame header sizes in bytes.
+const (
+	dc6HeaderSize      = 24
+	dc6FrameHeaderSize = 32
+	// Size of frame terminator.
+	dc6FrameTermSize = 3
+)
+
+// splitDC6 splits the given DC6 file into one DC6 file per frame, ordered by
+// direction and then frame. Each output file holds one direction of one frame.
+func splitDC6(data []byte) ([][]byte, error) {
+	if len(data) < dc6HeaderSize {
+		return nil, errors.Errorf("DC6 file too short; expected at least %d bytes, got %d", dc6HeaderSize, len(data))
+	}
+	directions := binary.LittleEndian.Uint32(data[16:20])
+	framesPerDir := binary.LittleEndian.Uint32(data[20:24])
+	nframes := uint64(directions) * uint64(framesPerDir)
+	if uint64(dc6HeaderSize)+4*nframes > uint64(len(data)) {
+		return nil, errors.Errorf("DC6 frame pointers of %d frames extend past end of file", nframes)
+	}
+	var frames [][]byte
+	for i := uint64(0); i < nframes; i++ {
+		ptr := uint64(binary.LittleEndian.Uint32(data[dc6HeaderSize+4*i:]))
+		if ptr+dc6FrameHeaderSize > uint64(len(data)) {
+			return nil, errors.Errorf("DC6 frame %d header at offset %d extends past end of file", i, ptr)
+		}
+		length := uint64(binary.LittleEndian.Uint32(data[ptr+28:]))
+		end := ptr + dc6FrameHeaderSize + length + dc6FrameTermSize
+		if end > uint64(len(data)) {
+			return nil, errors.Errorf("DC6 frame %d of %d bytes at offset %d extends past end of file", i, length, ptr)
+		}
+		block := data[ptr:end]
+		// Single-frame DC6 file; header, one frame pointer and the frame.
+		frame := make([]byte, dc6HeaderSize+4+len(block))
+		copy(frame, data[:dc6HeaderSize])
+		binary.LittleEndian.PutUint32(frame[16:], 1)
+		binary.LittleEndian.PutUint32(frame[20:], 1)
+		binary.LittleEndian.PutUint32(frame[dc6HeaderSize:], dc6HeaderSize+4)
+		copy(frame[dc6HeaderSize+4:], block)
+		// Next block pointer of frame.
+		binary.LittleEndian.PutUint32(frame[dc6HeaderSize+4+24:], uint32(len(frame)))
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/pkg/errors"
+	// Register pure-Go SQLite driver.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema is the schema of the SQLite database of extracted files.
+//
+// Each extracted file is stored as one row, where path is the normalized file
+// path (slash-separated, or backslash-separated with -path-style windows),
+// archive is the output directory name of the MPQ archive containing the file,
+// size is the uncompressed size in bytes and data is the decompressed contents
+// of the file.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS files (
+	path    TEXT NOT NULL,
+	archive TEXT NOT NULL,
+	size    INTEGER NOT NULL,
+	data    BLOB NOT NULL,
+	PRIMARY KEY (archive, path)
+)`
+
+// sqliteSink writes extracted files into a SQLite database.
+type sqliteSink struct {
+	// Path to SQLite database.
+	dbPath string
+	// SQLite database.
+	db *sql.DB
+	// Transaction of pending writes.
+	tx *sql.Tx
+	// Prepared insert statement of transaction.
+	insert *sql.Stmt
+	// Path separator style of stored file paths.
+	style pathStyle
+	// Serializes writes of parallel extraction.
+	mu sync.Mutex
+}
+
+// newSQLiteSink returns a new sink writing extracted files into the SQLite
+// database at the given path. The database is created if not present. File
+// paths are stored using the given path style.
+func newSQLiteSink(dbPath string, style pathStyle) (*sqliteSink, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, errors.WithStack(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, errors.WithStack(err)
+	}
+	insert, err := tx.Prepare("INSERT OR REPLACE INTO files (path, archive, size, data) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, errors.WithStack(err)
+	}
+	sink := &sqliteSink{
+		dbPath: dbPath,
+		db:     db,
+		tx:     tx,
+		insert: insert,
+		style:  style,
+	}
+	return sink, nil
+}
+
+// WriteFile stores the contents of the given file as a row of the files table.
+func (sink *sqliteSink) WriteFile(archiveDir, filePath string, data []byte) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	filePath = sink.style.format(filePath)
+	infof("storing: %q in %q\n", sink.style.format(archiveDir+"/")+filePath, sink.dbPath)
+	if _, err := sink.insert.Exec(filePath, archiveDir, len(data), data); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Close commits the pending writes and closes the SQLite database.
+func (sink *sqliteSink) Close() error {
+	if err := sink.insert.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := sink.tx.Commit(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := sink.db.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
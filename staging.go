@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// newStagingDir creates a new staging directory alongside the given output
+// directory (so that both reside on the same file system), and returns its
+// path.
+func newStagingDir(outDir string) (string, error) {
+	parent := filepath.Dir(filepath.Clean(outDir))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", errors.WithStack(err)
+	}
+	stagingDir, err := ioutil.TempDir(parent, filepath.Base(outDir)+".staging-")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return stagingDir, nil
+}
+
+// commitStaging replaces the output directory with the staging directory. Any
+// previous output directory is first renamed aside and removed once the staging
+// directory is in place, so consumers never observe a partially extracted
+// output directory.
+func commitStaging(stagingDir, outDir string) error {
+	var oldDir string
+	if _, err := os.Stat(outDir); err == nil {
+		oldDir = stagingDir + ".old"
+		if err := os.Rename(outDir, oldDir); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if err := os.Rename(stagingDir, outDir); err != nil {
+		return errors.WithStack(err)
+	}
+	if len(oldDir) > 0 {
+		if err := os.RemoveAll(oldDir); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// writeStdout writes the decompressed contents of the given file, read from the
+// first MPQ archive containing it, to standard output. Only one file is
+// supported, as the contents of multiple files would be indistinguishable.
+func writeStdout(archives []*d2mpq.MPQ, filePaths []string, opts options) error {
+	if len(filePaths) != 1 {
+		return errors.Errorf("-stdout supports only one file, got %d; specify a single file using -files", len(filePaths))
+	}
+	filePath := filePaths[0]
+	infof("extracting %q\n", filePath)
+	data, _, err := readFile(archives, filePath, opts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := os.Stdout.Write(data); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
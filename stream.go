@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// canStream reports whether files may be streamed to the sink during
+// extraction, rather than read into memory; i.e. if no option requires the
+// entire file contents (e.g. to detect file types, verify CRCs or pipe
+// contents through a command).
+func canStream(opts options) bool {
+	return opts.cache == nil &&
+		opts.timings == nil &&
+		opts.verify == nil &&
+		opts.typeCounts == nil &&
+		opts.splitTypes == nil &&
+		len(opts.pipeCmd) == 0 &&
+		!opts.localeFallback
+}
+
+// streamFile extracts the file from the first MPQ archive containing the file
+// path by streaming its decompressed contents to the sink, and returns the
+// number of bytes extracted and whether the file was written, as done by
+// extractFile. At most one sector of the file is held in memory
+// at a time.
+func streamFile(archives []*d2mpq.MPQ, filePath string, sink streamSink, opts options) (int, bool, error) {
+	if len(filePath) == 0 {
+		return 0, false, errors.Wrap(ErrNotFound, "empty file path")
+	}
+	key := archivePath(filePath)
+	archive, ok := findArchive(archives, key)
+	if !ok {
+		return 0, false, errors.Wrapf(ErrNotFound, "file not found %q", key)
+	}
+	reportPrecedence(archives, filePath, archive, opts)
+	if opts.showOffsets {
+		block, _ := archive.FileBlock(key)
+		infof("offset: 0x%08X\n", block.Position())
+	}
+	dir := archiveDir(archive, opts)
+	if opts.lower {
+		dir = strings.ToLower(dir)
+	}
+	dstPath := outputPath(filePath, opts)
+	opts.locks.lock(archive)
+	defer opts.locks.unlock(archive)
+	rc, err := archive.OpenFile(key)
+	if err != nil {
+		return 0, false, errors.Wrap(ErrFileRead, err.Error())
+	}
+	defer rc.Close()
+	r := &readErrorReader{r: rc}
+	n, err := sink.WriteStream(dir, dstPath, r)
+	if r.err != nil {
+		return 0, false, errors.Wrap(ErrFileRead, r.err.Error())
+	}
+	if errors.Cause(err) == ErrSkipped {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	if err := checkFileSize(archive, filePath, int(n), opts); err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	if opts.modTimes != nil {
+		if err := opts.modTimes.preserve(archive, filePath, sink, dir, dstPath); err != nil {
+			return 0, false, errors.WithStack(err)
+		}
+	}
+	if opts.manifest != nil {
+		opts.manifest.addFile(archive, filePath, dir, dstPath, int(n))
+	}
+	return int(n), true, nil
+}
+
+// readErrorReader records the read errors of the underlying reader, to tell
+// them apart from write errors when copying.
+type readErrorReader struct {
+	r io.Reader
+	// First read error other than io.EOF.
+	err error
+}
+
+// Read reads from the underlying reader, recording read errors.
+func (r *readErrorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// extractSummary summarizes the results of extracting files.
+type extractSummary struct {
+	// Number of files extracted; counted once per group of MPQ archives the
+	// file was extracted from.
+	extracted int
+	// Number of files skipped rather than written; counted once per group of
+	// MPQ archives the file was skipped from.
+	skipped int
+	// Number of files not found in any of the MPQ archives.
+	missing int
+	// Number of files which failed to extract.
+	errors int
+	// Total number of bytes extracted.
+	bytes int64
+}
+
+// add adds the result of extracting a file to the summary.
+func (s *extractSummary) add(result extractResult) {
+	s.extracted += result.extracted
+	s.skipped += result.skipped
+	s.errors += result.readErrors + result.failed
+	s.bytes += result.n
+	switch {
+	case result.err != nil:
+		s.errors++
+	case !result.found:
+		s.missing++
+	}
+}
+
+// String returns the summary line of the extraction results.
+func (s extractSummary) String() string {
+	return fmt.Sprintf("extracted %d, skipped %d, missing %d, errors %d; wrote %d bytes", s.extracted, s.skipped, s.missing, s.errors, s.bytes)
+}
+
+// failure returns an error if any error was skipped during extraction with
+// opts.continueOnError set, so that the run exits with a non-zero status.
+func (s extractSummary) failure(opts options) error {
+	if !opts.continueOnError || s.errors == 0 {
+		return nil
+	}
+	return errors.Errorf("%d error(s) during extraction; see log", s.errors)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/OpenDiablo2/MpqViewer/internal/mpqtest"
+)
+
+func TestExtractSummarySkipped(t *testing.T) {
+	const (
+		booksPath     = `data\global\excel\books.txt`
+		charstatsPath = `data\global\excel\charstats.txt`
+		palettePath   = `data\global\palette\pal.dat`
+	)
+	palette := make([]byte, 768)
+	for i := range palette {
+		palette[i] = byte(i)
+	}
+	archives := loadTestArchives(t, t.TempDir(),
+		testArchive{name: "d2data.mpq", Archive: mpqtest.Archive{Files: []mpqtest.File{
+			{Name: booksPath, Data: books, Compression: mpqtest.CompressionZlib},
+			{Name: charstatsPath, Data: charstats, Compression: mpqtest.CompressionZlib},
+			{Name: palettePath, Data: palette},
+		}}},
+		testArchive{name: "patch_d2.mpq", Archive: mpqtest.Archive{Files: []mpqtest.File{
+			{Name: booksPath, Data: books},
+		}}},
+	)
+	filePaths := []string{booksPath, charstatsPath, palettePath}
+	d2data, patch := archives[:1], archives[1:]
+
+	golden := []struct {
+		name   string
+		groups [][]*d2mpq.MPQ
+		opts   options
+		// Extract the files once before the extraction of the test.
+		rerun bool
+		want  extractSummary
+	}{
+		{name: "written", groups: [][]*d2mpq.MPQ{d2data}, want: extractSummary{extracted: 3}},
+		{name: "type filter", groups: [][]*d2mpq.MPQ{d2data}, opts: options{typeCounts: &typeCounts{}, typeFilter: "text"}, want: extractSummary{extracted: 2, skipped: 1}},
+		{name: "no-clobber", groups: [][]*d2mpq.MPQ{d2data}, opts: options{existing: existingNoClobber}, rerun: true, want: extractSummary{skipped: 3}},
+		{name: "resume-extract", groups: [][]*d2mpq.MPQ{d2data}, opts: options{existing: existingResume}, rerun: true, want: extractSummary{skipped: 3}},
+		{name: "flat collision", groups: [][]*d2mpq.MPQ{d2data, patch}, opts: options{flat: true}, want: extractSummary{extracted: 3, skipped: 1}},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			dir := t.TempDir()
+			extract := func(opts options) extractSummary {
+				sink := &dirSink{root: dir, noClobber: opts.existing == existingNoClobber, flat: newFlatClaims(opts)}
+				var summary extractSummary
+				for _, filePath := range filePaths {
+					result := extractFileGroups(g.groups, filePath, sink, opts)
+					if result.err != nil {
+						t.Fatalf("unexpected error; %+v", result.err)
+					}
+					summary.add(result)
+				}
+				return summary
+			}
+			if g.rerun {
+				extract(options{})
+			}
+			got := extract(g.opts)
+			got.bytes = 0
+			if got != g.want {
+				t.Errorf("summary mismatch; expected %v, got %v", g.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"archive/tar"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tarSink writes extracted files into a tar archive.
+type tarSink struct {
+	// Path to tar archive.
+	tarPath string
+	// Tar archive file.
+	f *os.File
+	// Tar writer of archive file.
+	w *tar.Writer
+	// Modification time of tar entries; the start of extraction.
+	modTime time.Time
+	// Output paths claimed in flat mode; nil if not flat.
+	flat *flatClaims
+	// Serializes writes of parallel extraction.
+	mu sync.Mutex
+}
+
+// newTarSink returns a new sink writing extracted files into the tar archive at
+// the given path. The tar archive is created, or truncated if present. When
+// opts.flat is set, tar entries are named by file path only; and by archive and
+// file path otherwise.
+func newTarSink(tarPath string, opts options) (*tarSink, error) {
+	f, err := os.Create(tarPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sink := &tarSink{
+		tarPath: tarPath,
+		f:       f,
+		w:       tar.NewWriter(f),
+		modTime: time.Now(),
+		flat:    newFlatClaims(opts),
+	}
+	return sink, nil
+}
+
+// WriteFile writes the contents of the given file as a tar entry named
+// archiveDir/filePath, or filePath in flat mode.
+func (sink *tarSink) WriteFile(archiveDir, filePath string, data []byte) error {
+	name := path.Join(archiveDir, filePath)
+	if sink.flat != nil {
+		if !sink.flat.claim(archiveDir, filePath) {
+			return nil
+		}
+		name = filePath
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	infof("storing: %q in %q\n", name, sink.tarPath)
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  sink.modTime,
+	}
+	if err := sink.w.WriteHeader(hdr); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := sink.w.Write(data); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Close flushes the pending writes and closes the tar archive.
+func (sink *tarSink) Close() error {
+	if err := sink.w.Close(); err != nil {
+		sink.f.Close()
+		return errors.WithStack(err)
+	}
+	if err := sink.f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import "github.com/pkg/errors"
+
+// makeRaw is not supported on this platform.
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("-interactive is only supported on Linux, macOS and FreeBSD")
+}
+
+// termSize returns the default terminal size of 80x24.
+func termSize(fd int) (int, int) {
+	return 80, 24
+}
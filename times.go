@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// timePreserver sets the modification time of extracted files to the time
+// recorded in the (attributes) file of MPQ archives, and the modification time
+// of output directories to the latest time of the files they contain.
+type timePreserver struct {
+	mu sync.Mutex
+	// MPQ archives without file times, already reported.
+	unavailable map[*d2mpq.MPQ]bool
+	// Maps from output directory path to the latest modification time of the
+	// files extracted below the directory.
+	dirTimes map[string]time.Time
+}
+
+// newTimePreserver returns a new preserver of the modification time of
+// extracted files.
+func newTimePreserver() *timePreserver {
+	return &timePreserver{
+		unavailable: make(map[*d2mpq.MPQ]bool),
+		dirTimes:    make(map[string]time.Time),
+	}
+}
+
+// preserve sets the modification time of the given file extracted from the MPQ
+// archive to the file dstPath of the output directory name archiveDir, as
+// written by sink. Files without a recorded modification time keep the time of
+// extraction, and MPQ archives without file times are reported once. Only
+// files written to disk are updated.
+//
+// The time is recorded for each directory between the output root directory
+// and the file, to be set by preserveDirs once all files have been written.
+func (p *timePreserver) preserve(archive *d2mpq.MPQ, filePath string, sink Sink, archiveDir, dstPath string) error {
+	s, ok := sink.(*dirSink)
+	if !ok || s.dryRun {
+		return nil
+	}
+	modTime, err := archive.FileTime(archivePath(filePath))
+	if err != nil {
+		if errors.Cause(err) != d2mpq.ErrNoAttributes {
+			return errors.WithStack(err)
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if !p.unavailable[archive] {
+			log.Printf("MPQ archive %q has no file times in (attributes); extracted files keep the time of extraction\n", archive.FileName)
+			p.unavailable[archive] = true
+		}
+		return nil
+	}
+	if modTime.IsZero() {
+		return nil
+	}
+	outPath, ok := s.writtenPath(archiveDir, dstPath)
+	if !ok {
+		return nil
+	}
+	if err := os.Chtimes(outPath, time.Now(), modTime); err != nil {
+		return errors.WithStack(err)
+	}
+	rel, err := filepath.Rel(s.root, outPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+		dirPath := filepath.Join(s.root, dir)
+		if modTime.After(p.dirTimes[dirPath]) {
+			p.dirTimes[dirPath] = modTime
+		}
+	}
+	return nil
+}
+
+// preserveDirs sets the modification time of each output directory to the
+// latest modification time of the files extracted below it. Directories are
+// updated deepest first, once all files have been written, as writing a file
+// updates the modification time of its directory.
+func (p *timePreserver) preserveDirs() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var dirPaths []string
+	for dirPath := range p.dirTimes {
+		dirPaths = append(dirPaths, dirPath)
+	}
+	sep := string(filepath.Separator)
+	sort.Slice(dirPaths, func(i, j int) bool {
+		di, dj := strings.Count(dirPaths[i], sep), strings.Count(dirPaths[j], sep)
+		if di != dj {
+			return di > dj
+		}
+		return dirPaths[i] < dirPaths[j]
+	})
+	for _, dirPath := range dirPaths {
+		if err := os.Chtimes(dirPath, time.Now(), p.dirTimes[dirPath]); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
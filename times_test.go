@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OpenDiablo2/MpqViewer/internal/mpqtest"
+)
+
+func TestPreserveTimes(t *testing.T) {
+	var (
+		booksTime     = time.Date(2001, 6, 27, 12, 0, 0, 0, time.UTC)
+		charstatsTime = time.Date(2001, 6, 29, 12, 0, 0, 0, time.UTC)
+		introTime     = time.Date(2000, 6, 1, 12, 0, 0, 0, time.UTC)
+	)
+	a := mpqtest.Archive{
+		Files: []mpqtest.File{
+			{Name: `data\global\excel\books.txt`, Data: books, Compression: mpqtest.CompressionZlib, ModTime: booksTime},
+			{Name: `data\global\excel\charstats.txt`, Data: charstats, Compression: mpqtest.CompressionZlib, ModTime: charstatsTime},
+			{Name: `data\global\music\intro.wav`, Data: books, ModTime: introTime},
+			// No recorded time.
+			{Name: `data\readme.txt`, Data: books},
+		},
+		Attributes: true,
+	}
+	filePaths := []string{`data\global\excel\books.txt`, `data\global\excel\charstats.txt`, `data\global\music\intro.wav`, `data\readme.txt`}
+
+	archiveDirTimes := map[string]time.Time{
+		"d2data/data/global/excel/books.txt":     booksTime,
+		"d2data/data/global/excel/charstats.txt": charstatsTime,
+		"d2data/data/global/music/intro.wav":     introTime,
+		"d2data/data/global/excel":               charstatsTime,
+		"d2data/data/global/music":               introTime,
+		"d2data/data/global":                     charstatsTime,
+		"d2data/data":                            charstatsTime,
+		"d2data":                                 charstatsTime,
+	}
+
+	golden := []struct {
+		name string
+		flat bool
+		// Number of extraction workers; 0 to extract serially.
+		jobs int
+		// Expected modification time of paths relative to the output
+		// directory.
+		want map[string]time.Time
+		// Path of the file without recorded time, relative to the output
+		// directory.
+		untimed string
+	}{
+		{
+			name:    "archive dir",
+			flat:    false,
+			want:    archiveDirTimes,
+			untimed: "d2data/data/readme.txt",
+		},
+		{
+			name:    "archive dir parallel",
+			flat:    false,
+			jobs:    4,
+			want:    archiveDirTimes,
+			untimed: "d2data/data/readme.txt",
+		},
+		{
+			name: "flat",
+			flat: true,
+			want: map[string]time.Time{
+				"data/global/excel/books.txt":     booksTime,
+				"data/global/excel/charstats.txt": charstatsTime,
+				"data/global/music/intro.wav":     introTime,
+				"data/global/excel":               charstatsTime,
+				"data/global/music":               introTime,
+				"data/global":                     charstatsTime,
+				"data":                            charstatsTime,
+			},
+			untimed: "data/readme.txt",
+		},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			archives := loadTestArchives(t, t.TempDir(), testArchive{name: "d2data.mpq", Archive: a})
+			outDir := t.TempDir()
+			opts := options{flat: g.flat, jobs: g.jobs, locks: newArchiveLocks(archives), diagnostics: &diagnostics{}, modTimes: newTimePreserver()}
+			sink, err := newSink(outDir, "", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := extractAllFiles(archives, filePaths, sink, opts); err != nil {
+				t.Fatalf("unexpected error; %+v", err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := opts.modTimes.preserveDirs(); err != nil {
+				t.Fatal(err)
+			}
+			for relPath, want := range g.want {
+				fi, err := os.Stat(filepath.Join(outDir, relPath))
+				if err != nil {
+					t.Errorf("unable to stat %q; %v", relPath, err)
+					continue
+				}
+				if !fi.ModTime().Equal(want) {
+					t.Errorf("%q: modification time mismatch; expected %v, got %v", relPath, want, fi.ModTime().UTC())
+				}
+			}
+			// Files without a recorded time keep the time of extraction.
+			fi, err := os.Stat(filepath.Join(outDir, g.untimed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if time.Since(fi.ModTime()) > time.Hour {
+				t.Errorf("modification time of file without recorded time changed; got %v", fi.ModTime())
+			}
+		})
+	}
+}
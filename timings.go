@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+)
+
+// fileTiming records how long it took to read a file.
+type fileTiming struct {
+	// File path.
+	filePath string
+	// MPQ archive containing file.
+	archive *d2mpq.MPQ
+	// Duration of read.
+	elapsed time.Duration
+	// Size in bytes of decompressed contents.
+	size int
+}
+
+// timings records the read duration of each extracted file.
+type timings struct {
+	mu      sync.Mutex
+	entries []fileTiming
+}
+
+// add records the read duration of the given file.
+func (t *timings) add(entry fileTiming) {
+	t.mu.Lock()
+	t.entries = append(t.entries, entry)
+	t.mu.Unlock()
+}
+
+// printSlowest prints the n slowest files to read, along with their sizes and
+// compression methods.
+func (t *timings) printSlowest(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := append([]fileTiming(nil), t.entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].elapsed > entries[j].elapsed
+	})
+	if n > len(entries) {
+		n = len(entries)
+	}
+	fmt.Printf("slowest %d file(s) to read:\n", n)
+	for i, entry := range entries[:n] {
+		block, _ := entry.archive.FileBlock(archivePath(entry.filePath))
+		fmt.Printf("%d. %q\t%v\t%d bytes\t%s\n", i+1, normalize(entry.filePath), entry.elapsed, entry.size, compressionMethod(entry.archive, block))
+	}
+}
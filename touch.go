@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// touchFiles creates a zero-byte placeholder file below root at the output path
+// of each file contained in the MPQ archives, without reading the contents of
+// the files. touchFiles returns the number of placeholders created.
+//
+// As with extractAllFiles, a placeholder is created for every labelled MPQ
+// archive containing the file, and for the first unlabelled MPQ archive
+// containing it.
+func touchFiles(archives []*d2mpq.MPQ, filePaths []string, root string, opts options) (int, error) {
+	groups := archiveGroups(archives, opts)
+	n := 0
+	for _, filePath := range filePaths {
+		found := false
+		for _, group := range groups {
+			archive, ok := findArchive(group, filePath)
+			if !ok {
+				continue
+			}
+			found = true
+			dir := archiveDir(archive, opts)
+			if opts.lower {
+				dir = strings.ToLower(dir)
+			}
+			if opts.flat {
+				dir = ""
+			}
+			dstPath := normalize(filepath.Join(root, dir, outputPath(filePath, opts)))
+			if err := touchFile(dstPath); err != nil {
+				return n, errors.WithStack(err)
+			}
+			n++
+		}
+		if !found {
+			recordNotFound(filePath, opts)
+		}
+	}
+	return n, nil
+}
+
+// findArchive returns the first MPQ archive containing the given file path.
+func findArchive(archives []*d2mpq.MPQ, filePath string) (*d2mpq.MPQ, bool) {
+	filePath = archivePath(filePath)
+	for _, archive := range archives {
+		if archive.FileExists(filePath) {
+			return archive, true
+		}
+	}
+	return nil, false
+}
+
+// touchFile creates an empty file at the given path, creating parent
+// directories as needed. An existing file is truncated.
+func touchFile(dstPath string) error {
+	infof("creating: %q\n", dstPath)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := os.Create(dstPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// treeNode is a node in the directory tree of files in MPQ archives.
+type treeNode struct {
+	// Base name of file or directory.
+	name string
+	// File path within MPQ archive; empty for directories.
+	filePath string
+	// Child nodes of directory, keyed by base name; nil for files.
+	children map[string]*treeNode
+}
+
+// isDir reports whether the node is a directory.
+func (node *treeNode) isDir() bool {
+	return node.children != nil
+}
+
+// sortedChildren returns the child nodes of the directory, sorted by name with
+// directories first.
+func (node *treeNode) sortedChildren() []*treeNode {
+	var children []*treeNode
+	for _, child := range node.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].isDir() != children[j].isDir() {
+			return children[i].isDir()
+		}
+		return children[i].name < children[j].name
+	})
+	return children
+}
+
+// buildTree builds a directory tree of the given file paths, as present in MPQ
+// archives (i.e. backslash-separated).
+func buildTree(filePaths []string, lower bool) *treeNode {
+	root := &treeNode{children: make(map[string]*treeNode)}
+	for _, filePath := range filePaths {
+		outPath := normalize(filePath)
+		if lower {
+			outPath = strings.ToLower(outPath)
+		}
+		names := strings.Split(strings.Trim(outPath, "/"), "/")
+		dir := root
+		for i, name := range names {
+			if len(name) == 0 {
+				continue
+			}
+			child, ok := dir.children[name]
+			if !ok {
+				child = &treeNode{name: name}
+				dir.children[name] = child
+			}
+			if i == len(names)-1 {
+				child.filePath = filePath
+				break
+			}
+			if child.children == nil {
+				child.children = make(map[string]*treeNode)
+			}
+			dir = child
+		}
+	}
+	return root
+}
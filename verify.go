@@ -0,0 +1,50 @@
+package main
+
+import (
+	"hash/crc32"
+	"log"
+	"sync"
+
+	"github.com/OpenDiablo2/MpqViewer/d2mpq"
+	"github.com/pkg/errors"
+)
+
+// crcVerifier verifies the CRC32 of extracted files against the CRC32 recorded
+// in the (attributes) file of MPQ archives.
+type crcVerifier struct {
+	mu sync.Mutex
+	// MPQ archives without CRC32 values, already reported as unverifiable.
+	unavailable map[*d2mpq.MPQ]bool
+}
+
+// newCRCVerifier returns a new CRC32 verifier of extracted files.
+func newCRCVerifier() *crcVerifier {
+	return &crcVerifier{unavailable: make(map[*d2mpq.MPQ]bool)}
+}
+
+// verify checks the CRC32 of the given file contents read from the MPQ archive
+// against the CRC32 recorded in the (attributes) file of the MPQ archive. A
+// mismatch is reported as an ErrCRCMismatch error. MPQ archives without CRC32
+// values are reported once, as verification is unavailable for their files.
+func (v *crcVerifier) verify(archive *d2mpq.MPQ, filePath string, data []byte) error {
+	expected, ok, err := archive.FileCRC32(archivePath(filePath))
+	if err != nil {
+		if errors.Cause(err) != d2mpq.ErrNoAttributes {
+			return errors.WithStack(err)
+		}
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if !v.unavailable[archive] {
+			log.Printf("MPQ archive %q has no CRC32 values in (attributes); unable to verify its files\n", archive.FileName)
+			v.unavailable[archive] = true
+		}
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	if actual := crc32.ChecksumIEEE(data); actual != expected {
+		return errors.Wrapf(ErrCRCMismatch, "CRC32 of %q is 0x%08X; expected 0x%08X", filePath, actual, expected)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/zip"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// storedExts is the set of file extensions of already compressed files, which
+// are stored in zip archives without compression.
+var storedExts = map[string]bool{
+	".bik":  true,
+	".smk":  true,
+	".mp3":  true,
+	".ogg":  true,
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".zip":  true,
+	".gz":   true,
+	".mpq":  true,
+}
+
+// zipSink writes extracted files into a zip archive.
+type zipSink struct {
+	// Path to zip archive.
+	zipPath string
+	// Zip archive file.
+	f *os.File
+	// Zip writer of archive file.
+	w *zip.Writer
+	// Modification time of zip entries; the start of extraction.
+	modTime time.Time
+	// Output paths claimed in flat mode; nil if not flat.
+	flat *flatClaims
+	// Serializes writes of parallel extraction.
+	mu sync.Mutex
+}
+
+// newZipSink returns a new sink writing extracted files into the zip archive at
+// the given path. The zip archive is created, or truncated if present. When
+// opts.flat is set, zip entries are named by file path only; and by archive and
+// file path otherwise. As zip entries cannot be replaced,
+// opts.flatOverwrite is ignored.
+func newZipSink(zipPath string, opts options) (*zipSink, error) {
+	flat := newFlatClaims(opts)
+	if flat != nil && flat.overwrite {
+		log.Printf("ignoring -flat-overwrite when storing files in zip archive %q\n", zipPath)
+		flat.overwrite = false
+	}
+	f, err := os.Create(zipPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sink := &zipSink{
+		zipPath: zipPath,
+		f:       f,
+		w:       zip.NewWriter(f),
+		modTime: time.Now(),
+		flat:    flat,
+	}
+	return sink, nil
+}
+
+// WriteFile writes the contents of the given file as a zip entry named
+// archiveDir/filePath, or filePath in flat mode. Already compressed files (see
+// storedExts) are stored, and other files are deflated.
+func (sink *zipSink) WriteFile(archiveDir, filePath string, data []byte) error {
+	name := path.Join(archiveDir, filePath)
+	if sink.flat != nil {
+		if !sink.flat.claim(archiveDir, filePath) {
+			return nil
+		}
+		name = filePath
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	infof("storing: %q in %q\n", name, sink.zipPath)
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: sink.modTime,
+	}
+	if storedExts[strings.ToLower(path.Ext(name))] {
+		hdr.Method = zip.Store
+	}
+	hdr.SetMode(0644)
+	w, err := sink.w.CreateHeader(hdr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Close flushes the pending writes and closes the zip archive.
+func (sink *zipSink) Close() error {
+	if err := sink.w.Close(); err != nil {
+		sink.f.Close()
+		return errors.WithStack(err)
+	}
+	if err := sink.f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}